@@ -0,0 +1,66 @@
+/*
+Copyright © 2024 Jose Fernandez <magec>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+
+	"github.com/pterm/pterm"
+
+	"github.com/spf13/cobra"
+)
+
+// destroyCmd represents the destroy command
+var destroyCmd = &cobra.Command{
+	Use:   "destroy",
+	Short: "Execute a nomad-pack destroy for every pack in the desired state",
+	Long: `This command will execute a nomad-pack destroy for every pack in the desired state,
+stopping and purging their jobs. Use --environment and --release to select what to destroy.
+
+Unless --yes is given, confirmation is asked before destroying anything.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		pterm.DefaultBasicText.Println("Compiling packfile.")
+		nomadPackFile := compilePackfile()
+
+		yes, _ := cmd.Flags().GetBool("yes")
+		if !yes {
+			confirmed, _ := pterm.DefaultInteractiveConfirm.Show("Destroy the jobs of every selected release?")
+			if !confirmed {
+				pterm.DefaultBasicText.Println("Nothing destroyed.")
+				return
+			}
+		}
+
+		pterm.DefaultBasicText.Println("Running pre-flight checks.")
+		exitOnError(nomadPackFile.Preflight())
+		pterm.DefaultBasicText.Println("Executing destroy for packfile.")
+		results, err := nomadPackFile.Destroy()
+		printSummary(results)
+		exitOnError(errors.Join(err, writeReports(cmd, results)))
+	},
+}
+
+func init() {
+	addJUnitFlag(destroyCmd)
+	destroyCmd.Flags().Bool("yes", false, "Do not ask for confirmation.")
+	rootCmd.AddCommand(destroyCmd)
+}
@@ -0,0 +1,79 @@
+/*
+Copyright © 2024 Jose Fernandez <magec>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/pterm/pterm"
+
+	"github.com/spf13/cobra"
+)
+
+// driftExitCode is the exit code of diff --detect-drift when any release would change the cluster.
+const driftExitCode = 2
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show the differences between the desired state and the clusters",
+	Long: `This command will execute a nomad-plan for every pack in the desired state and show the differences.
+
+With --detect-drift any change is considered drift: the command exits with code 2 when a
+release does not match its cluster (1 is still used for errors). Use --report json=drift.json
+to get a machine readable drift report per environment and release.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		pterm.DefaultBasicText.Println("Compiling packfile.")
+		nomadPackFile := compilePackfile()
+		pterm.DefaultBasicText.Println("Running pre-flight checks.")
+		exitOnError(nomadPackFile.Preflight())
+		pterm.DefaultBasicText.Println("Executing diff for packfile.")
+		results, err := nomadPackFile.Plan()
+		exitOnError(errors.Join(err, writeReports(cmd, results)))
+
+		drifted := 0
+		for _, result := range results {
+			if result.Result.Changes {
+				drifted++
+				pterm.Warning.Printf("Release %s in environment %s has drifted.\n", result.Release, result.Environment)
+			}
+		}
+		if drifted == 0 {
+			pterm.Success.Println("Every release matches its cluster.")
+			return
+		}
+
+		detectDrift, _ := cmd.Flags().GetBool("detect-drift")
+		if detectDrift {
+			pterm.Error.Printf("Drift detected in %d release(s).\n", drifted)
+			os.Exit(driftExitCode)
+		}
+	},
+}
+
+func init() {
+	addJUnitFlag(diffCmd)
+	diffCmd.Flags().Bool("detect-drift", false, "Exit with code 2 when any release does not match its cluster.")
+	diffCmd.Flags().StringArray("report", nil, "Write a report of the results, in the form format=path (supported formats: markdown, junit, json). Can be repeated.")
+	rootCmd.AddCommand(diffCmd)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright © 2024 Jose Fernandez <magec>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/pterm/pterm"
+
+	"github.com/spf13/cobra"
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history <release>",
+	Short: "List the recorded runs of a release",
+	Long: `This command will list the runs of a release recorded in Nomad Variables (under
+nomad-packfile/<release>) of its environment: who ran it, git SHA, pack and ref, hashes
+of the var-files and vars, status and time. Use --environment when the release is
+deployed to several environments.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		nomadPackFile := compilePackfile()
+		release, err := nomadPackFile.Release(cmd.Flag("environment").Value.String(), args[0])
+		exitOnError(err)
+
+		records, err := release.History()
+		exitOnError(err)
+		if len(records) == 0 {
+			pterm.Info.Printf("No runs recorded for release %s in environment %s.\n", release.Name, release.Environment)
+			return
+		}
+
+		data := pterm.TableData{{"Revision", "Time", "User", "Git SHA", "Pack", "Ref", "Status", "Var files", "Vars"}}
+		for _, record := range records {
+			data = append(data, []string{
+				fmt.Sprint(record.Revision),
+				record.Timestamp.Local().Format("2006-01-02 15:04:05"),
+				record.User,
+				shortHash(record.GitSHA),
+				record.Pack,
+				record.Ref,
+				record.Status,
+				shortHash(record.VarFilesHash),
+				shortHash(record.VarsHash),
+			})
+		}
+		pterm.DefaultTable.WithHasHeader().WithData(data).Render()
+	},
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+}
@@ -0,0 +1,60 @@
+/*
+Copyright © 2024 Jose Fernandez <magec>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"github.com/magec/nomad-packfile/internal/lock"
+	"github.com/magec/nomad-packfile/internal/nomadpackfile"
+	"github.com/pterm/pterm"
+
+	"github.com/spf13/cobra"
+)
+
+// lockCmd represents the lock command
+var lockCmd = &cobra.Command{
+	Use:     "lock",
+	Aliases: []string{"deps"},
+	Short:   "Pin the ref of every registry to a commit in packfile.lock",
+	Long: `This command will resolve the ref of every registry (latest when it is not set), and the refs
+releases override, to the commit they point to, using git ls-remote against the registry url, and
+write them to packfile.lock next to the packfile. The other commands then use the locked commits, so every run deploys exactly the
+same packs until the lock file is updated. Use --frozen on them to fail when the lock file is
+missing or out of date. The lock file covers every release, --environment and --release are ignored.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		lockFile, err := nomadpackfile.Lock(config.Unfiltered(), log)
+		exitOnError(err)
+
+		data := pterm.TableData{{"Registry", "URL", "Ref", "Commit"}}
+		for _, registry := range lockFile.Registries {
+			data = append(data, []string{registry.Name, registry.URL, registry.Ref, registry.Commit})
+		}
+		pterm.DefaultTable.WithHasHeader().WithData(data).Render()
+
+		path := lock.Path(config.WorkDir())
+		exitOnError(lockFile.Write(path))
+		pterm.Success.Printf("Wrote %s.\n", path)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lockCmd)
+}
@@ -22,7 +22,8 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"github.com/magec/nomad-packfile/internal/nomadpackfile"
+	"errors"
+
 	"github.com/pterm/pterm"
 
 	"github.com/spf13/cobra"
@@ -32,16 +33,23 @@ import (
 var planCmd = &cobra.Command{
 	Use:   "plan",
 	Short: "Execute a nomad-plan for every pack in the desired state",
-	Long:  `This command will execute a nomad-plan for every pack in the desired state.`,
+	Long: `This command will execute a nomad-plan for every pack in the desired state.
+
+Use --report format=path to write a report of the plan results, e.g. --report markdown=plan.md
+generates a summary suitable to be posted as a pull request comment.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		pterm.DefaultBasicText.Println("Compiling packfile.")
-		nomadPackFile := nomadpackfile.New(*config, log)
-		nomadPackFile.Compile()
+		nomadPackFile := compilePackfile()
+		pterm.DefaultBasicText.Println("Running pre-flight checks.")
+		exitOnError(nomadPackFile.Preflight())
 		pterm.DefaultBasicText.Println("Executing plan for packfile.")
-		nomadPackFile.Plan()
+		results, err := nomadPackFile.Plan()
+		exitOnError(errors.Join(err, writeReports(cmd, results)))
 	},
 }
 
 func init() {
+	addJUnitFlag(planCmd)
+	planCmd.Flags().StringArray("report", nil, "Write a report of the plan results, in the form format=path (supported formats: markdown, junit, json). Can be repeated.")
 	rootCmd.AddCommand(planCmd)
 }
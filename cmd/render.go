@@ -22,7 +22,8 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"github.com/magec/nomad-packfile/internal/nomadpackfile"
+	"errors"
+
 	"github.com/pterm/pterm"
 
 	"github.com/spf13/cobra"
@@ -32,16 +33,21 @@ import (
 var renderCmd = &cobra.Command{
 	Use:   "render",
 	Short: "Execute a nomad-render for every pack in the desired state",
-	Long:  `This command will execute a nomad-render for every pack in the desired state.`,
+	Long: `This command will execute a nomad-render for every pack in the desired state.
+
+When --output-dir is given, the rendered templates of every release are written to
+<output-dir>/<environment>/<release> instead of being printed.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		pterm.DefaultBasicText.Println("Compiling packfile.")
-		nomadPackFile := nomadpackfile.New(*config, log)
-		nomadPackFile.Compile()
+		nomadPackFile := compilePackfile()
 		pterm.DefaultBasicText.Println("Executing render for packfile.")
-		nomadPackFile.Render()
+		results, err := nomadPackFile.Render(cmd.Flag("output-dir").Value.String())
+		exitOnError(errors.Join(err, writeReports(cmd, results)))
 	},
 }
 
 func init() {
+	addJUnitFlag(renderCmd)
+	renderCmd.Flags().String("output-dir", "", "Write the rendered templates to <output-dir>/<environment>/<release> instead of printing them.")
 	rootCmd.AddCommand(renderCmd)
 }
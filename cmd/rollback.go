@@ -0,0 +1,63 @@
+/*
+Copyright © 2024 Jose Fernandez <magec>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"time"
+
+	"github.com/pterm/pterm"
+
+	"github.com/spf13/cobra"
+)
+
+// rollbackCmd represents the rollback command
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback <release> --to <revision>",
+	Short: "Run a release again with the inputs of a previous recorded revision",
+	Long: `This command will execute a nomad-run of a release with the inputs recorded for a previous
+revision (see the history command): the same pack and registry ref, vars and var files.
+Use --environment when the release is deployed to several environments.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		revision, _ := cmd.Flags().GetInt("to")
+		if revision <= 0 {
+			exitOnError(errors.New("a revision to roll back to is required, see the history command"))
+		}
+
+		pterm.DefaultBasicText.Println("Compiling packfile.")
+		nomadPackFile := compilePackfile()
+		pterm.DefaultBasicText.Println("Running pre-flight checks.")
+		exitOnError(nomadPackFile.Preflight())
+		results, err := nomadPackFile.Rollback(cmd.Flag("environment").Value.String(), args[0], revision)
+		printSummary(results)
+		exitOnError(errors.Join(err, writeReports(cmd, results)))
+	},
+}
+
+func init() {
+	addJUnitFlag(rollbackCmd)
+	rollbackCmd.Flags().Int("to", 0, "Revision of the release to roll back to.")
+	rollbackCmd.Flags().Bool("wait", false, "Wait for the deployments of the release to become healthy.")
+	rollbackCmd.Flags().Duration("wait-timeout", 5*time.Minute, "How long to wait for the deployments of the release.")
+	rootCmd.AddCommand(rollbackCmd)
+}
@@ -22,11 +22,17 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	configpkg "github.com/magec/nomad-packfile/internal/config"
 	"github.com/magec/nomad-packfile/internal/logger"
+	"github.com/magec/nomad-packfile/internal/nomadpackfile"
+	"github.com/magec/nomad-packfile/internal/redact"
+	"github.com/magec/nomad-packfile/internal/report"
+	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
@@ -36,6 +42,7 @@ var rootCmd = &cobra.Command{
 	Short: "Declaratively deploy your nomad-packs.",
 	Long:  "Declare the desired state of your packs and let nomad-packfile synchronize it with your Nomad cluster.",
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		pterm.SetDefaultOutput(redact.Writer(os.Stdout))
 		log = logger.NewLogger(cmd.Flag("log-level").Value.String())
 
 		var err error
@@ -51,6 +58,75 @@ var rootCmd = &cobra.Command{
 var log *zap.Logger
 var config *configpkg.Config
 
+// exitOnError prints err and exits with a non-zero status when err is not nil.
+func exitOnError(err error) {
+	if err != nil {
+		pterm.Error.Println(err)
+		os.Exit(1)
+	}
+}
+
+// compilePackfile compiles the packfile with the nomad-pack runner, exiting on errors.
+func compilePackfile() *nomadpackfile.NomadPackFile {
+	runner, err := nomadpackfile.NewRunner(*config, log)
+	exitOnError(err)
+
+	nomadPackFile := nomadpackfile.New(*config, runner, log)
+	exitOnError(nomadPackFile.Compile())
+	return nomadPackFile
+}
+
+// addJUnitFlag adds --junit to a command that runs nomad-pack for every release.
+func addJUnitFlag(cmd *cobra.Command) {
+	cmd.Flags().String("junit", "", "Write the results as a JUnit XML report to this path.")
+}
+
+// writeReports writes the reports requested on the command line (--junit and, when the
+// command supports it, --report) for results.
+func writeReports(cmd *cobra.Command, results []nomadpackfile.ReleaseResult) error {
+	specs := []string{}
+	if junit := cmd.Flag("junit").Value.String(); junit != "" {
+		specs = append(specs, "junit="+junit)
+	}
+	if cmd.Flags().Lookup("report") != nil {
+		reports, _ := cmd.Flags().GetStringArray("report")
+		specs = append(specs, reports...)
+	}
+
+	var errs []error
+	for _, spec := range specs {
+		err := report.Write(spec, results)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not write report %s: %w", spec, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// printSummary prints a table with the outcome of every release, including the deployments
+// followed and the rollbacks made.
+func printSummary(results []nomadpackfile.ReleaseResult) {
+	data := pterm.TableData{{"Environment", "Release", "Status", "Deployments"}}
+	for _, result := range results {
+		status := "ok"
+		if result.Failed() {
+			status = "failed"
+		}
+		deployments := []string{}
+		for _, deployment := range result.Result.Deployments {
+			description := fmt.Sprintf("%s v%d: %s", deployment.Job(), deployment.JobVersion, deployment.Status)
+			if deployment.RolledBackTo != nil {
+				description += fmt.Sprintf(", rolled back to v%d", *deployment.RolledBackTo)
+			}
+			deployments = append(deployments, description)
+		}
+		data = append(data, []string{result.Environment, result.Release, status, strings.Join(deployments, "\n")})
+	}
+
+	pterm.DefaultSection.Println("Summary")
+	pterm.DefaultTable.WithHasHeader().WithData(data).Render()
+}
+
 func Execute() {
 
 	err := rootCmd.Execute()
@@ -65,4 +141,7 @@ func init() {
 	rootCmd.PersistentFlags().StringP("file", "f", "packfile.yaml", `Load config from file or directory`)
 	rootCmd.PersistentFlags().String("nomad-pack-binary", "nomad-pack", `Path to the nomad-pack binary.`)
 	rootCmd.PersistentFlags().String("log-level", "fatal", `Log Level.`)
+	rootCmd.PersistentFlags().Bool("quiet", false, `Only show nomad-pack output of the releases that fail.`)
+	rootCmd.PersistentFlags().Bool("frozen", false, `Fail if packfile.lock is missing or does not match the registries.`)
+	rootCmd.PersistentFlags().Bool("refresh-registries", false, `Add the registries even if nomad-pack has them cached.`)
 }
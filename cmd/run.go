@@ -22,7 +22,9 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"github.com/magec/nomad-packfile/internal/nomadpackfile"
+	"errors"
+	"time"
+
 	"github.com/pterm/pterm"
 
 	"github.com/spf13/cobra"
@@ -32,16 +34,27 @@ import (
 var runCmd = &cobra.Command{
 	Use:   "run",
 	Short: "Execute a nomad-run for every pack in the desired state",
-	Long:  `This command will execute a nomad-run for every pack in the desired state.`,
+	Long: `This command will execute a nomad-run for every pack in the desired state.
+
+With --wait, after every release is run its Nomad deployments are followed until they
+succeed, fail or --wait-timeout expires. Releases can override both with the wait and
+wait-timeout settings, and opt-in to revert their jobs to the previous stable version when
+the deployment fails with rollback-on-failure.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		pterm.DefaultBasicText.Println("Compiling packfile.")
-		nomadPackFile := nomadpackfile.New(*config, log)
-		nomadPackFile.Compile()
+		nomadPackFile := compilePackfile()
+		pterm.DefaultBasicText.Println("Running pre-flight checks.")
+		exitOnError(nomadPackFile.Preflight())
 		pterm.DefaultBasicText.Println("Executing run for packfile.")
-		nomadPackFile.Run()
+		results, err := nomadPackFile.Run()
+		printSummary(results)
+		exitOnError(errors.Join(err, writeReports(cmd, results)))
 	},
 }
 
 func init() {
+	addJUnitFlag(runCmd)
+	runCmd.Flags().Bool("wait", false, "Wait for the deployments of every release to become healthy.")
+	runCmd.Flags().Duration("wait-timeout", 5*time.Minute, "How long to wait for the deployments of a release.")
 	rootCmd.AddCommand(runCmd)
 }
@@ -0,0 +1,100 @@
+/*
+Copyright © 2024 Jose Fernandez <magec>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/magec/nomad-packfile/internal/nomadpackfile"
+	"github.com/magec/nomad-packfile/internal/redact"
+	"github.com/pterm/pterm"
+
+	"github.com/spf13/cobra"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the live state of every release in the desired state",
+	Long: `This command will show, for every release in the desired state, the jobs of its pack as they
+are running in Nomad: version, status, running/desired allocations, last deployment and submit time.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output := cmd.Flag("output").Value.String()
+		switch output {
+		case "table":
+		case "json":
+			// Keep stdout for the JSON document.
+			pterm.SetDefaultOutput(redact.Writer(os.Stderr))
+		default:
+			exitOnError(fmt.Errorf("unknown output format %q, expected table or json", output))
+		}
+
+		pterm.DefaultBasicText.Println("Compiling packfile.")
+		nomadPackFile := compilePackfile()
+		pterm.DefaultBasicText.Println("Checking the connection to the clusters.")
+		exitOnError(nomadPackFile.CheckConnections())
+		statuses, err := nomadPackFile.Status()
+
+		if output == "json" {
+			encoder := json.NewEncoder(redact.Writer(os.Stdout))
+			encoder.SetIndent("", "  ")
+			exitOnError(encoder.Encode(statuses))
+		} else {
+			printStatus(statuses)
+		}
+		exitOnError(err)
+	},
+}
+
+func printStatus(statuses []nomadpackfile.ReleaseStatus) {
+	data := pterm.TableData{{"Environment", "Release", "Job", "Version", "Status", "Allocations", "Deployment", "Submitted"}}
+	for _, status := range statuses {
+		if status.Error != "" {
+			data = append(data, []string{status.Environment, status.Release, "", "", "error: " + status.Error, "", "", ""})
+		}
+		for _, job := range status.Jobs {
+			submitted := ""
+			if !job.SubmitTime.IsZero() {
+				submitted = job.SubmitTime.Local().Format("2006-01-02 15:04:05")
+			}
+			data = append(data, []string{
+				status.Environment,
+				status.Release,
+				job.Job().String(),
+				fmt.Sprint(job.Version),
+				job.Status,
+				fmt.Sprintf("%d/%d", job.Running, job.Desired),
+				job.DeploymentStatus,
+				submitted,
+			})
+		}
+	}
+
+	pterm.DefaultTable.WithHasHeader().WithData(data).Render()
+}
+
+func init() {
+	statusCmd.Flags().StringP("output", "o", "table", "Output format: table or json.")
+	rootCmd.AddCommand(statusCmd)
+}
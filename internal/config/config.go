@@ -1,8 +1,13 @@
 package config
 
 import (
+	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
@@ -15,52 +20,198 @@ type RegistryConfig struct {
 	Target *string `yaml:"target"`
 }
 
+// EnvPassthroughConfig selects, using glob patterns, the variables of the environment passed to nomad-pack.
+type EnvPassthroughConfig struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
 type ReleaseConfig struct {
-	Name             string            `yaml:"name"`
-	Pack             string            `yaml:"pack"`
-	VarFiles         []string          `yaml:"var-files"`
-	Vars             map[string]string `yaml:"vars"`
-	Environments     []string          `yaml:"environments"`
-	EnvironmentFiles []string          `yaml:"environment-files"`
-	NomadAddr        string            `yaml:"nomad-addr"`
-	NomadToken       string            `yaml:"nomad-token"`
+	Name               string               `yaml:"name"`
+	Pack               string               `yaml:"pack"`
+	Ref                string               `yaml:"ref"`
+	VarFiles           []string             `yaml:"var-files"`
+	Vars               map[string]string    `yaml:"vars"`
+	SensitiveVars      []string             `yaml:"sensitive-vars"`
+	Environments       []string             `yaml:"environments"`
+	EnvironmentFiles   []string             `yaml:"environment-files"`
+	Secrets            []string             `yaml:"secrets"`
+	NomadAddr          string               `yaml:"nomad-addr"`
+	NomadToken         string               `yaml:"nomad-token"`
+	NomadNamespace     string               `yaml:"nomad-namespace"`
+	NomadRegion        string               `yaml:"nomad-region"`
+	NomadCACert        string               `yaml:"nomad-cacert"`
+	NomadClientCert    string               `yaml:"nomad-client-cert"`
+	NomadClientKey     string               `yaml:"nomad-client-key"`
+	NomadTLSServerName string               `yaml:"nomad-tls-server-name"`
+	NomadSkipVerify    string               `yaml:"nomad-skip-verify"`
+	EnvPassthrough     EnvPassthroughConfig `yaml:"env-passthrough"`
+	Env                map[string]string    `yaml:"env"`
+	Wait               *bool                `yaml:"wait"`
+	WaitTimeout        string               `yaml:"wait-timeout"`
+	RollbackOnFailure  *bool                `yaml:"rollback-on-failure"`
+	// SourceFile is the file of the packfile the release or environment was declared in.
+	SourceFile string `yaml:"-"`
+}
+
+// Dir returns the directory relative paths of the release are resolved against: the one of the
+// file that declared it, workDir when unknown.
+func (release ReleaseConfig) Dir(workDir string) string {
+	if release.SourceFile == "" {
+		return workDir
+	}
+	return filepath.Dir(release.SourceFile)
 }
 
 type Config struct {
-	Registries      []RegistryConfig         `yaml:"registries"`
-	Environments    map[string]ReleaseConfig `yaml:"environments"`
-	Releases        []ReleaseConfig          `yaml:"releases"`
-	Path            string                   `yaml:"-"`
-	NomadPackBinary string                   `yaml:"-"`
+	Registries        []RegistryConfig         `yaml:"registries"`
+	Environments      map[string]ReleaseConfig `yaml:"environments"`
+	Releases          []ReleaseConfig          `yaml:"releases"`
+	EnvPassthrough    EnvPassthroughConfig     `yaml:"env-passthrough"`
+	NomadPackVersion  string                   `yaml:"nomad-pack-version"`
+	Path              string                   `yaml:"-"`
+	NomadPackBinary   string                   `yaml:"-"`
+	Quiet             bool                     `yaml:"-"`
+	Frozen            bool                     `yaml:"-"`
+	RefreshRegistries bool                     `yaml:"-"`
+	Wait              bool                     `yaml:"-"`
+	WaitTimeout       time.Duration            `yaml:"-"`
+
+	// unfiltered are the environments and releases before Filter.
+	unfiltered *Config
 }
 
-// WorkDir returns the directory where the packfile is located.
+// WorkDir returns the directory where the packfile is located, the packfile itself when it is a
+// directory of files.
 func (config *Config) WorkDir() string {
 	path := config.Path
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return path
+	}
 	return filepath.Dir(path)
 }
 
-func NewFromFile(file string, cmd *cobra.Command) (*Config, error) {
+// Load reads the packfile at path, a YAML file or a directory of them (packfile.yaml and
+// *.packfile.yaml, also in subdirectories, in lexical order) which are merged: registries and
+// releases are appended and every environment must be defined in a single file.
+func Load(path string) (Config, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Config{}, err
+	}
+	if !info.IsDir() {
+		return loadFile(path)
+	}
+
+	files, err := packfileFiles(path)
+	if err != nil {
+		return Config{}, err
+	}
+	if len(files) == 0 {
+		return Config{}, fmt.Errorf("no packfile (packfile.yaml or *.packfile.yaml) found in %s", path)
+	}
+
+	config := Config{Environments: map[string]ReleaseConfig{}}
+	environmentFiles := map[string]string{}
+	for _, file := range files {
+		fileConfig, err := loadFile(file)
+		if err != nil {
+			return Config{}, err
+		}
+		config.Registries = append(config.Registries, fileConfig.Registries...)
+		config.Releases = append(config.Releases, fileConfig.Releases...)
+		config.EnvPassthrough.Allow = append(config.EnvPassthrough.Allow, fileConfig.EnvPassthrough.Allow...)
+		config.EnvPassthrough.Deny = append(config.EnvPassthrough.Deny, fileConfig.EnvPassthrough.Deny...)
+		for name, environment := range fileConfig.Environments {
+			if previous, found := environmentFiles[name]; found {
+				return Config{}, fmt.Errorf("environment %s is defined in both %s and %s", name, previous, file)
+			}
+			environmentFiles[name] = file
+			config.Environments[name] = environment
+		}
+		if fileConfig.NomadPackVersion != "" {
+			if config.NomadPackVersion != "" && config.NomadPackVersion != fileConfig.NomadPackVersion {
+				return Config{}, fmt.Errorf("nomad-pack-version is set to different values in %s", path)
+			}
+			config.NomadPackVersion = fileConfig.NomadPackVersion
+		}
+	}
+	return config, nil
+}
+
+// packfileFiles returns the packfile files in dir and its subdirectories, sorted. Hidden
+// directories and packs (directories with a metadata.hcl file) are skipped.
+func packfileFiles(dir string) ([]string, error) {
+	files := []string{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != dir && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(path, "metadata.hcl")); err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isPackfileName(entry.Name()) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// isPackfileName returns whether name is the name of a file of a packfile directory: packfile.yaml,
+// or ending in .packfile.yaml, also with the .yml extension. Other YAML files, like secrets or CI
+// files, are not packfiles.
+func isPackfileName(name string) bool {
+	base, found := strings.CutSuffix(name, ".yaml")
+	if !found {
+		base, found = strings.CutSuffix(name, ".yml")
+	}
+	return found && (base == "packfile" || strings.HasSuffix(base, ".packfile"))
+}
+
+func loadFile(file string) (Config, error) {
 	config := Config{}
 
 	yamlFile, err := os.ReadFile(file)
 	if err != nil {
-		return nil, err
+		return Config{}, err
 	}
 
 	err = yaml.Unmarshal(yamlFile, &config)
 	if err != nil {
-		return nil, err
+		return Config{}, fmt.Errorf("%s: %w", file, err)
+	}
+
+	for i := range config.Releases {
+		config.Releases[i].SourceFile = file
+	}
+	for name, environment := range config.Environments {
+		environment.SourceFile = file
+		config.Environments[name] = environment
+	}
+	return config, nil
+}
+
+// Filter keeps only the given environment and the releases with the given name, when they are not
+// empty. Unfiltered returns the config as it was before.
+func (config *Config) Filter(environment, release string) {
+	if config.unfiltered == nil {
+		config.unfiltered = &Config{Environments: config.Environments, Releases: config.Releases}
 	}
 
-	environment, err := cmd.Flags().GetString("environment")
 	if environment != "" {
 		newEnvironments := map[string]ReleaseConfig{}
 		newEnvironments[environment] = config.Environments[environment]
 		config.Environments = newEnvironments
 	}
 
-	release, err := cmd.Flags().GetString("release")
 	if release != "" {
 		newReleases := []ReleaseConfig{}
 		for _, r := range config.Releases {
@@ -70,9 +221,63 @@ func NewFromFile(file string, cmd *cobra.Command) (*Config, error) {
 		}
 		config.Releases = newReleases
 	}
+}
+
+// Unfiltered returns the config with every environment and release of the packfile, whatever
+// Filter selected.
+func (config Config) Unfiltered() Config {
+	if config.unfiltered != nil {
+		config.Environments, config.Releases = config.unfiltered.Environments, config.unfiltered.Releases
+		config.unfiltered = nil
+	}
+	return config
+}
+
+func NewFromFile(file string, cmd *cobra.Command) (*Config, error) {
+	config, err := Load(file)
+	if err != nil {
+		return nil, err
+	}
+
+	environment, err := cmd.Flags().GetString("environment")
+	if err != nil {
+		return nil, err
+	}
+	release, err := cmd.Flags().GetString("release")
+	if err != nil {
+		return nil, err
+	}
+	config.Filter(environment, release)
 
 	config.Path = file
 	config.NomadPackBinary, err = cmd.Flags().GetString("nomad-pack-binary")
+	if err != nil {
+		return nil, err
+	}
+
+	config.Quiet, err = cmd.Flags().GetBool("quiet")
+	if err != nil {
+		return nil, err
+	}
+
+	config.Frozen, err = cmd.Flags().GetBool("frozen")
+	if err != nil {
+		return nil, err
+	}
+
+	config.RefreshRegistries, err = cmd.Flags().GetBool("refresh-registries")
+	if err != nil {
+		return nil, err
+	}
+
+	// Flags only defined by some of the commands
+	if cmd.Flags().Lookup("wait") != nil {
+		config.Wait, err = cmd.Flags().GetBool("wait")
+		if err != nil {
+			return nil, err
+		}
+		config.WaitTimeout, err = cmd.Flags().GetDuration("wait-timeout")
+	}
 
 	return &config, err
 }
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "packfile.yaml"), `
+environments:
+  staging: {}
+registries:
+  - name: myorg
+    url: github.com/myorg/packs
+`)
+	writeFile(t, filepath.Join(dir, "team-a", "releases.packfile.yml"), `
+releases:
+  - name: app
+    pack: ./packs/app
+`)
+	writeFile(t, filepath.Join(dir, "team-a", "packs", "app", "metadata.hcl"), "")
+	writeFile(t, filepath.Join(dir, "team-a", "packs", "app", "values.packfile.yaml"), "not: [a packfile")
+	writeFile(t, filepath.Join(dir, "team-a", "secrets.yaml"), "releases: ENC[AES256_GCM,data:...]\n")
+	writeFile(t, filepath.Join(dir, ".gitlab-ci.yml"), "stages: [deploy]\n")
+
+	config, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Environments) != 1 || len(config.Registries) != 1 || len(config.Releases) != 1 {
+		t.Fatalf("expected the files to be merged, got %+v", config)
+	}
+	if source := config.Releases[0].SourceFile; source != filepath.Join(dir, "team-a", "releases.packfile.yml") {
+		t.Errorf("expected the release to know its file, got %s", source)
+	}
+
+	config.Path = dir
+	if config.WorkDir() != dir {
+		t.Errorf("expected the directory to be the work dir, got %s", config.WorkDir())
+	}
+}
+
+func TestLoadDirectoryDuplicateEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.packfile.yaml"), "environments:\n  staging: {}\n")
+	writeFile(t, filepath.Join(dir, "b.packfile.yaml"), "environments:\n  staging: {}\n")
+
+	_, err := Load(dir)
+	if err == nil || !strings.Contains(err.Error(), "environment staging is defined in both") {
+		t.Errorf("expected a duplicate environment error, got %v", err)
+	}
+
+	empty := t.TempDir()
+	writeFile(t, filepath.Join(empty, "docker-compose.yaml"), "services: {}\n")
+	_, err = Load(empty)
+	if err == nil || !strings.Contains(err.Error(), "no packfile") {
+		t.Errorf("expected an error for a directory without packfiles, got %v", err)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	config := Config{
+		Environments: map[string]ReleaseConfig{"staging": {}, "production": {}},
+		Releases:     []ReleaseConfig{{Name: "app"}, {Name: "worker"}},
+		Path:         "packfile.yaml",
+	}
+	config.Filter("staging", "app")
+	if len(config.Environments) != 1 || len(config.Releases) != 1 || config.Releases[0].Name != "app" {
+		t.Fatalf("expected only staging and app, got %+v", config)
+	}
+
+	unfiltered := config.Unfiltered()
+	if len(unfiltered.Environments) != 2 || len(unfiltered.Releases) != 2 || unfiltered.Path != "packfile.yaml" {
+		t.Errorf("expected every environment and release, got %+v", unfiltered)
+	}
+	if len(config.Releases) != 1 {
+		t.Errorf("expected the filtered config to be kept, got %+v", config)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		err = os.WriteFile(path, []byte(content), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
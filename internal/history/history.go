@@ -0,0 +1,346 @@
+// Package history keeps an audit trail of the runs of every release in Nomad Variables.
+// Every run is stored as a variable at nomad-packfile/<release>/<revision>.
+package history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/magec/nomad-packfile/internal/nomadpack"
+)
+
+// PathPrefix is the prefix of the Nomad Variables nomad-packfile writes to.
+const PathPrefix = "nomad-packfile"
+
+// Statuses of a recorded run.
+const (
+	StatusDeployed   = "deployed"
+	StatusFailed     = "failed"
+	StatusRolledBack = "rolled-back"
+)
+
+// VarFile is a var file as it was when the release was run.
+type VarFile struct {
+	// Name is the name the var file is written with by WriteVarFiles, a local path.
+	Name string `json:"name"`
+	// Path is the path of the var file as it was passed to nomad-pack.
+	Path    string `json:"path,omitempty"`
+	Content string `json:"content"`
+}
+
+// Record describes a run of a release. Besides the audit information it keeps the inputs
+// needed to run the release again: the pack, its registry and the rendered vars and var files.
+// Vars containing secrets are not kept, only their names in SensitiveVars.
+type Record struct {
+	Revision     int
+	Release      string
+	Environment  string
+	User         string
+	GitSHA       string
+	Pack         string
+	Ref          string
+	VarFilesHash string
+	VarsHash     string
+	Status       string
+	Timestamp    time.Time
+	// RollbackOf is the revision this run rolled back to, if it was a rollback.
+	RollbackOf int
+
+	PackName    string
+	Registry    string
+	RegistryURL string
+	Vars        map[string]string
+	// SensitiveVars are the names of the vars left out of Vars because they contain secrets.
+	SensitiveVars []string
+	VarFiles      []VarFile
+}
+
+// HasInputs returns whether the record contains what is needed to run the release again.
+func (record Record) HasInputs() bool {
+	return record.PackName != ""
+}
+
+// items returns the record as the items of a Nomad Variable.
+func (record Record) items() (nomad.VariableItems, error) {
+	vars, err := json.Marshal(record.Vars)
+	if err != nil {
+		return nil, err
+	}
+	varFiles, err := json.Marshal(record.VarFiles)
+	if err != nil {
+		return nil, err
+	}
+	sensitiveVars, err := json.Marshal(record.SensitiveVars)
+	if err != nil {
+		return nil, err
+	}
+
+	items := nomad.VariableItems{
+		"release":        record.Release,
+		"environment":    record.Environment,
+		"user":           record.User,
+		"git_sha":        record.GitSHA,
+		"pack":           record.Pack,
+		"ref":            record.Ref,
+		"var_files_hash": record.VarFilesHash,
+		"vars_hash":      record.VarsHash,
+		"status":         record.Status,
+		"timestamp":      record.Timestamp.UTC().Format(time.RFC3339),
+		"pack_name":      record.PackName,
+		"registry":       record.Registry,
+		"registry_url":   record.RegistryURL,
+		"vars":           string(vars),
+		"var_files":      string(varFiles),
+		"sensitive_vars": string(sensitiveVars),
+	}
+	if record.RollbackOf != 0 {
+		items["rollback_of"] = strconv.Itoa(record.RollbackOf)
+	}
+	return items, nil
+}
+
+func recordFromVariable(variable *nomad.Variable) (Record, error) {
+	revision, err := strconv.Atoi(path.Base(variable.Path))
+	if err != nil {
+		return Record{}, fmt.Errorf("invalid history variable %s", variable.Path)
+	}
+	items := variable.Items
+	timestamp, _ := time.Parse(time.RFC3339, items["timestamp"])
+	rollbackOf, _ := strconv.Atoi(items["rollback_of"])
+
+	record := Record{
+		Revision:     revision,
+		Release:      items["release"],
+		Environment:  items["environment"],
+		User:         items["user"],
+		GitSHA:       items["git_sha"],
+		Pack:         items["pack"],
+		Ref:          items["ref"],
+		VarFilesHash: items["var_files_hash"],
+		VarsHash:     items["vars_hash"],
+		Status:       items["status"],
+		Timestamp:    timestamp,
+		RollbackOf:   rollbackOf,
+		PackName:     items["pack_name"],
+		Registry:     items["registry"],
+		RegistryURL:  items["registry_url"],
+	}
+	// Records written by older versions do not have the inputs.
+	if items["vars"] != "" {
+		err = json.Unmarshal([]byte(items["vars"]), &record.Vars)
+		if err != nil {
+			return Record{}, fmt.Errorf("invalid vars in %s: %w", variable.Path, err)
+		}
+	}
+	if items["var_files"] != "" {
+		err = json.Unmarshal([]byte(items["var_files"]), &record.VarFiles)
+		if err != nil {
+			return Record{}, fmt.Errorf("invalid var files in %s: %w", variable.Path, err)
+		}
+	}
+	if items["sensitive_vars"] != "" {
+		err = json.Unmarshal([]byte(items["sensitive_vars"]), &record.SensitiveVars)
+		if err != nil {
+			return Record{}, fmt.Errorf("invalid sensitive vars in %s: %w", variable.Path, err)
+		}
+	}
+
+	return record, nil
+}
+
+// Store reads and writes the history of the releases of a Nomad cluster.
+type Store struct {
+	client *nomad.Client
+}
+
+// New returns a Store for the cluster of connection.
+func New(connection nomadpack.Connection) (*Store, error) {
+	client, err := nomad.NewClient(connection.APIConfig())
+	if err != nil {
+		return nil, err
+	}
+	return &Store{client: client}, nil
+}
+
+func releasePrefix(release string) string {
+	return PathPrefix + "/" + release + "/"
+}
+
+// List returns the records of release in environment, oldest first. Environments sharing a cluster
+// share the revisions of their releases, the ones of other environments are skipped.
+func (store *Store) List(release, environment string) ([]Record, error) {
+	records, err := store.list(release)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(records, func(record Record) bool { return record.Environment != environment }), nil
+}
+
+// list returns the records of release in every environment, oldest first.
+func (store *Store) list(release string) ([]Record, error) {
+	variables, _, err := store.client.Variables().PrefixList(releasePrefix(release), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not list the history of release %s: %w", release, err)
+	}
+
+	records := []Record{}
+	for _, metadata := range variables {
+		// Skip the variables of releases whose name starts with this one.
+		if path.Dir(metadata.Path) != strings.TrimSuffix(releasePrefix(release), "/") {
+			continue
+		}
+		variable, _, err := store.client.Variables().Read(metadata.Path, nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", metadata.Path, err)
+		}
+		record, err := recordFromVariable(variable)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	slices.SortFunc(records, func(a, b Record) int { return a.Revision - b.Revision })
+
+	return records, nil
+}
+
+// Get returns the given revision of release, which must have been run in environment.
+func (store *Store) Get(release, environment string, revision int) (Record, error) {
+	variable, _, err := store.client.Variables().Read(releasePrefix(release)+strconv.Itoa(revision), nil)
+	if err != nil && !errors.Is(err, nomad.ErrVariablePathNotFound) {
+		return Record{}, fmt.Errorf("could not read revision %d of release %s: %w", revision, release, err)
+	}
+	if variable == nil {
+		return Record{}, fmt.Errorf("revision %d of release %s not found", revision, release)
+	}
+	record, err := recordFromVariable(variable)
+	if err != nil {
+		return Record{}, err
+	}
+	if record.Environment != environment {
+		return Record{}, fmt.Errorf("revision %d of release %s was run in environment %s, not in %s", revision, release, record.Environment, environment)
+	}
+	return record, nil
+}
+
+// Add stores record as the next revision of its release and returns it with the revision set.
+func (store *Store) Add(record Record) (Record, error) {
+	records, err := store.list(record.Release)
+	if err != nil {
+		return record, err
+	}
+	record.Revision = 1
+	if len(records) > 0 {
+		record.Revision = records[len(records)-1].Revision + 1
+	}
+
+	items, err := record.items()
+	if err != nil {
+		return record, err
+	}
+	variable := &nomad.Variable{
+		Path:  releasePrefix(record.Release) + strconv.Itoa(record.Revision),
+		Items: items,
+	}
+	_, _, err = store.client.Variables().CheckedCreate(variable, nil)
+	if err != nil {
+		return record, fmt.Errorf("could not store revision %d of release %s: %w", record.Revision, record.Release, err)
+	}
+
+	return record, nil
+}
+
+// CurrentUser returns the name of the user running nomad-packfile.
+func CurrentUser() string {
+	if current, err := user.Current(); err == nil && current.Username != "" {
+		return current.Username
+	}
+	return os.Getenv("USER")
+}
+
+// GitSHA returns the commit checked out in dir, or an empty string if dir is not in a git repository.
+func GitSHA(dir string) string {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// ReadVarFiles returns the var files at paths, relative ones to dir, with their contents. Their
+// names are made of their position and base name, so that any path can be written back.
+func ReadVarFiles(dir string, paths []string) ([]VarFile, error) {
+	varFiles := []VarFile{}
+	for i, path := range paths {
+		source := path
+		if !filepath.IsAbs(source) {
+			source = filepath.Join(dir, path)
+		}
+		content, err := os.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("%d-%s", i, filepath.Base(path))
+		varFiles = append(varFiles, VarFile{Name: name, Path: path, Content: string(content)})
+	}
+	return varFiles, nil
+}
+
+// WriteVarFiles writes the var files into dir, with 0600 permissions as they may contain secrets.
+func WriteVarFiles(dir string, varFiles []VarFile) error {
+	for _, varFile := range varFiles {
+		if !filepath.IsLocal(varFile.Name) {
+			return fmt.Errorf("invalid var file name %q", varFile.Name)
+		}
+		path := filepath.Join(dir, varFile.Name)
+		err := os.MkdirAll(filepath.Dir(path), 0700)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(path, []byte(varFile.Content), 0600)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HashVarFiles returns a hash of the names and contents of the var files.
+func HashVarFiles(varFiles []VarFile) string {
+	hash := sha256.New()
+	for _, varFile := range varFiles {
+		fmt.Fprintf(hash, "%s\x00%d\x00", varFile.Name, len(varFile.Content))
+		hash.Write([]byte(varFile.Content))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// HashVars returns a hash of the vars which does not depend on their order.
+func HashVars(vars map[string]string) string {
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s\x00%s\x00", key, vars[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
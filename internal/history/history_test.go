@@ -0,0 +1,166 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/magec/nomad-packfile/internal/nomadpack"
+	"github.com/magec/nomad-packfile/test/nomadtest"
+)
+
+func TestHashVars(t *testing.T) {
+	a := HashVars(map[string]string{"image": "app:1", "replicas": "2"})
+	b := HashVars(map[string]string{"replicas": "2", "image": "app:1"})
+	c := HashVars(map[string]string{"image": "app:2", "replicas": "2"})
+
+	if a != b {
+		t.Errorf("expected the hash not to depend on the order of the vars")
+	}
+	if a == c {
+		t.Errorf("expected different vars to have different hashes")
+	}
+}
+
+func TestHashVarFiles(t *testing.T) {
+	a := HashVarFiles([]VarFile{{Name: "common.hcl", Content: `image = "app:1"`}})
+	b := HashVarFiles([]VarFile{{Name: "common.hcl", Content: `image = "app:2"`}})
+	if a == b {
+		t.Errorf("expected the hash to change with the content of the var files")
+	}
+}
+
+func TestReadWriteVarFiles(t *testing.T) {
+	dir := t.TempDir()
+	shared := filepath.Join(t.TempDir(), "common.hcl")
+	files := map[string]string{
+		filepath.Join(dir, "nomad", "staging.hcl"): `count = 2`,
+		filepath.Join(dir, "staging.hcl"):          `count = 3`,
+		shared:                                     `image = "app:1"`,
+	}
+	for path, content := range files {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = os.WriteFile(path, []byte(content), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read, err := ReadVarFiles(filepath.Join(dir, "nomad"), []string{shared, "staging.hcl", "../staging.hcl"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []VarFile{
+		{Name: "0-common.hcl", Path: shared, Content: `image = "app:1"`},
+		{Name: "1-staging.hcl", Path: "staging.hcl", Content: `count = 2`},
+		{Name: "2-staging.hcl", Path: "../staging.hcl", Content: `count = 3`},
+	}
+	if !slices.Equal(read, expected) {
+		t.Errorf("expected %v, got %v", expected, read)
+	}
+
+	written := t.TempDir()
+	err = WriteVarFiles(written, read)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, varFile := range expected {
+		content, err := os.ReadFile(filepath.Join(written, varFile.Name))
+		if err != nil || string(content) != varFile.Content {
+			t.Errorf("expected %s to contain %q, got %q %v", varFile.Name, varFile.Content, content, err)
+		}
+	}
+	info, err := os.Stat(filepath.Join(written, "1-staging.hcl"))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected var file to be written with 0600 permissions: %v", err)
+	}
+
+	_, err = ReadVarFiles(dir, []string{"missing.hcl"})
+	if err == nil {
+		t.Errorf("expected an error for a missing var file")
+	}
+	err = WriteVarFiles(written, []VarFile{{Name: "../outside.hcl"}})
+	if err == nil {
+		t.Errorf("expected an error for a var file outside of the directory")
+	}
+}
+
+func TestRecordItemsRoundTrip(t *testing.T) {
+	record := Record{
+		Revision:      3,
+		Release:       "app",
+		Environment:   "staging",
+		User:          "deployer",
+		GitSHA:        "0123abc",
+		Pack:          "registry://myorg/app",
+		Ref:           "v1.0.0",
+		Status:        "deployed",
+		Timestamp:     time.Date(2024, 8, 1, 10, 0, 0, 0, time.UTC),
+		RollbackOf:    1,
+		PackName:      "app",
+		Registry:      "myorg",
+		RegistryURL:   "github.com/myorg/packs",
+		Vars:          map[string]string{"image": "app:1"},
+		SensitiveVars: []string{"password"},
+		VarFiles:      []VarFile{{Name: "nomad/common.hcl", Content: `count = 1`}},
+	}
+
+	items, err := record.items()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	parsed, err := recordFromVariable(&nomad.Variable{Path: "nomad-packfile/app/3", Items: items})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, record) {
+		t.Errorf("expected %+v, got %+v", record, parsed)
+	}
+}
+
+func TestStoreEnvironments(t *testing.T) {
+	server := nomadtest.NewServer(t)
+	store, err := New(nomadpack.Connection{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both environments deploy to the same cluster.
+	for _, environment := range []string{"staging", "production", "staging"} {
+		_, err = store.Add(Record{Release: "app", Environment: environment})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = store.Add(Record{Release: "app-worker", Environment: "staging"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := store.List("app", "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Revision != 1 || records[1].Revision != 3 {
+		t.Errorf("expected revisions 1 and 3, got %+v", records)
+	}
+
+	record, err := store.Get("app", "production", 2)
+	if err != nil || record.Environment != "production" {
+		t.Errorf("expected revision 2 of production, got %+v %v", record, err)
+	}
+	_, err = store.Get("app", "staging", 2)
+	if err == nil || !strings.Contains(err.Error(), "was run in environment production, not in staging") {
+		t.Errorf("expected the revision of another environment to be rejected, got %v", err)
+	}
+	_, err = store.Get("app", "staging", 4)
+	if err == nil || !strings.Contains(err.Error(), "revision 4 of release app not found") {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
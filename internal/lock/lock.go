@@ -0,0 +1,173 @@
+// Package lock pins the refs of the registries of a packfile to commit SHAs. The pinned refs are
+// kept in packfile.lock, next to the packfile, so every run uses exactly the same packs.
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	configpkg "github.com/magec/nomad-packfile/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the name of the lock file, which lives in the directory of the packfile.
+const FileName = "packfile.lock"
+
+// LatestRef is the ref nomad-pack uses when a registry does not set one, the head of its default branch.
+const LatestRef = "latest"
+
+const header = "# This file is generated by nomad-packfile lock, do not edit it.\n"
+
+// Registry is a registry ref pinned to a commit.
+type Registry struct {
+	Name   string `yaml:"name"`
+	URL    string `yaml:"url"`
+	Ref    string `yaml:"ref"`
+	Commit string `yaml:"commit"`
+}
+
+// Lock is the content of a lock file.
+type Lock struct {
+	Registries []Registry `yaml:"registries"`
+}
+
+// Path returns the path of the lock file of the packfile in dir.
+func Path(dir string) string {
+	return filepath.Join(dir, FileName)
+}
+
+// Ref returns the ref of the registry, LatestRef when it is not set.
+func Ref(registry configpkg.RegistryConfig) string {
+	if registry.Ref == nil || *registry.Ref == "" {
+		return LatestRef
+	}
+	return *registry.Ref
+}
+
+// Read reads the lock file at path. The error wraps os.ErrNotExist when there is none.
+func Read(path string) (*Lock, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lock := Lock{}
+	err = yaml.Unmarshal(content, &lock)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lock file %s: %w", path, err)
+	}
+	return &lock, nil
+}
+
+// Write writes the lock file to path.
+func (lock *Lock) Write(path string) error {
+	content, err := yaml.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(header), content...), 0644)
+}
+
+// Find returns the commit the ref of the registry is pinned to. It is not found when the lock
+// file is stale for the registry, i.e. its url or ref changed since it was locked.
+func (lock *Lock) Find(registry configpkg.RegistryConfig) (Registry, bool) {
+	for _, locked := range lock.Registries {
+		if locked.Name == registry.Name && locked.URL == registry.URL && locked.Ref == Ref(registry) {
+			return locked, true
+		}
+	}
+	return Registry{}, false
+}
+
+// Stale returns the registry refs, as name@ref, that are not pinned by the lock file or whose
+// registry url changed since they were pinned.
+func (lock *Lock) Stale(registries []configpkg.RegistryConfig) []string {
+	stale := []string{}
+	for _, registry := range registries {
+		if _, found := lock.Find(registry); !found {
+			stale = append(stale, registry.Name+"@"+Ref(registry))
+		}
+	}
+	return stale
+}
+
+// New resolves the ref of every registry to a commit. A registry may be given once per ref it is
+// used at. Relative local registry urls are relative to dir.
+func New(registries []configpkg.RegistryConfig, dir string) (*Lock, error) {
+	lock := Lock{Registries: []Registry{}}
+	var errs []error
+	for _, registry := range registries {
+		ref := Ref(registry)
+		commit, err := Resolve(registry.URL, ref, dir)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("registry %s@%s: %w", registry.Name, ref, err))
+			continue
+		}
+		lock.Registries = append(lock.Registries, Registry{Name: registry.Name, URL: registry.URL, Ref: ref, Commit: commit})
+	}
+	return &lock, errors.Join(errs...)
+}
+
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// Resolve returns the commit ref points to in the git repository at url, using git ls-remote.
+// Tags are resolved to the commit they point to, LatestRef to the head of the default branch
+// and a ref that already is a commit SHA is returned as is.
+func Resolve(url, ref, dir string) (string, error) {
+	if commitPattern.MatchString(ref) {
+		return ref, nil
+	}
+
+	remote := remoteURL(url, dir)
+	cmd := exec.Command("git", "ls-remote", remote)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("git ls-remote %s failed: %s", remote, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", err
+	}
+
+	refs := map[string]string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		commit, name, found := strings.Cut(line, "\t")
+		if found {
+			refs[name] = commit
+		}
+	}
+
+	if ref == LatestRef {
+		ref = "HEAD"
+	}
+	// An annotated tag is listed with the commit it points to as refs/tags/<tag>^{}.
+	for _, name := range []string{"refs/tags/" + ref + "^{}", "refs/tags/" + ref, "refs/heads/" + ref, ref} {
+		if commit, found := refs[name]; found {
+			return commit, nil
+		}
+	}
+	return "", fmt.Errorf("ref %s not found in %s", ref, remote)
+}
+
+// remoteURL returns the url of the registry as understood by git. Like nomad-pack, urls without a
+// scheme that are not local directories are fetched over https, e.g. github.com/myorg/packs.
+func remoteURL(url, dir string) string {
+	url = strings.TrimPrefix(url, "git::")
+	if strings.Contains(url, "://") || strings.HasPrefix(url, "git@") {
+		return url
+	}
+	path := url
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return url
+	}
+	return "https://" + url
+}
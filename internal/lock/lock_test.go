@@ -0,0 +1,152 @@
+package lock
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	configpkg "github.com/magec/nomad-packfile/internal/config"
+)
+
+func TestResolve(t *testing.T) {
+	dir, commits := gitRepository(t)
+
+	cases := map[string]string{
+		"v1.0.0":   commits[0],
+		"v1.0.1":   commits[1],
+		"main":     commits[1],
+		LatestRef:  commits[1],
+		commits[0]: commits[0],
+	}
+	for ref, expected := range cases {
+		commit, err := Resolve(dir, ref, t.TempDir())
+		if err != nil {
+			t.Errorf("Resolve(%s): %v", ref, err)
+			continue
+		}
+		if commit != expected {
+			t.Errorf("Resolve(%s) = %s, expected %s", ref, commit, expected)
+		}
+	}
+
+	_, err := Resolve(dir, "v9.9.9", t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "v9.9.9 not found") {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
+
+func TestResolveRelativeURL(t *testing.T) {
+	dir, commits := gitRepository(t)
+
+	commit, err := Resolve(filepath.Base(dir), "v1.0.0", filepath.Dir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit != commits[0] {
+		t.Errorf("expected %s, got %s", commits[0], commit)
+	}
+}
+
+func TestNewReadWrite(t *testing.T) {
+	dir, commits := gitRepository(t)
+	ref := "v1.0.0"
+	registries := []configpkg.RegistryConfig{{Name: "myorg", URL: dir, Ref: &ref}, {Name: "other", URL: dir}}
+
+	lock, err := New(registries, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := Path(t.TempDir())
+	err = lock.Write(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Registry{
+		{Name: "myorg", URL: dir, Ref: "v1.0.0", Commit: commits[0]},
+		{Name: "other", URL: dir, Ref: LatestRef, Commit: commits[1]},
+	}
+	if !slices.Equal(read.Registries, expected) {
+		t.Errorf("expected %+v, got %+v", expected, read.Registries)
+	}
+
+	_, err = Read(filepath.Join(t.TempDir(), FileName))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+}
+
+func TestStale(t *testing.T) {
+	v1, v2 := "v1.0.0", "v2.0.0"
+	lock := Lock{Registries: []Registry{
+		{Name: "myorg", URL: "github.com/myorg/packs", Ref: "v1.0.0", Commit: "a"},
+		{Name: "unused", URL: "github.com/unused/packs", Ref: LatestRef, Commit: "b"},
+	}}
+
+	stale := lock.Stale([]configpkg.RegistryConfig{
+		{Name: "myorg", URL: "github.com/myorg/packs", Ref: &v1},
+		{Name: "myorg", URL: "github.com/myorg/packs", Ref: &v2},
+		{Name: "new", URL: "github.com/new/packs"},
+	})
+	if !slices.Equal(stale, []string{"myorg@v2.0.0", "new@latest"}) {
+		t.Errorf("expected myorg@v2.0.0 and new@latest to be stale, got %v", stale)
+	}
+
+	stale = lock.Stale([]configpkg.RegistryConfig{{Name: "myorg", URL: "github.com/myorg/moved", Ref: &v1}})
+	if !slices.Equal(stale, []string{"myorg@v1.0.0"}) {
+		t.Errorf("expected a changed url to be stale, got %v", stale)
+	}
+}
+
+func TestRemoteURL(t *testing.T) {
+	cases := map[string]string{
+		"github.com/myorg/packs":                "https://github.com/myorg/packs",
+		"https://github.com/myorg/packs":        "https://github.com/myorg/packs",
+		"git::ssh://git@github.com/myorg/packs": "ssh://git@github.com/myorg/packs",
+		"git@github.com:myorg/packs.git":        "git@github.com:myorg/packs.git",
+	}
+	for url, expected := range cases {
+		if remote := remoteURL(url, t.TempDir()); remote != expected {
+			t.Errorf("remoteURL(%s) = %s, expected %s", url, remote, expected)
+		}
+	}
+}
+
+// gitRepository creates a git repository with two commits on main, tagged v1.0.0 (annotated) and
+// v1.0.1, and returns its path and the commits.
+func gitRepository(t *testing.T) (string, []string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com", "GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com", "GIT_CONFIG_GLOBAL=/dev/null")
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+
+	git("init", "--initial-branch=main")
+	commits := []string{}
+	for _, tag := range []string{"v1.0.0", "v1.0.1"} {
+		git("commit", "--allow-empty", "-m", tag)
+		commits = append(commits, git("rev-parse", "HEAD"))
+	}
+	git("tag", "-a", "v1.0.0", "-m", "v1.0.0", commits[0])
+	git("tag", "v1.0.1")
+	return dir, commits
+}
@@ -3,10 +3,41 @@ package logger
 import (
 	"os"
 
+	"github.com/magec/nomad-packfile/internal/redact"
 	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
 	"go.uber.org/zap/zapcore"
 )
 
+// redactingEncoder masks the registered secrets in every log entry.
+type redactingEncoder struct {
+	zapcore.Encoder
+}
+
+func (e redactingEncoder) Clone() zapcore.Encoder {
+	return redactingEncoder{e.Encoder.Clone()}
+}
+
+func (e redactingEncoder) EncodeEntry(entry zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	buf, err := e.Encoder.EncodeEntry(entry, fields)
+	if err != nil {
+		return nil, err
+	}
+	redacted := redact.String(buf.String())
+	buf.Reset()
+	buf.AppendString(redacted)
+	return buf, nil
+}
+
+func init() {
+	err := zap.RegisterEncoder("redacted-console", func(config zapcore.EncoderConfig) (zapcore.Encoder, error) {
+		return redactingEncoder{zapcore.NewConsoleEncoder(config)}, nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
 func stringToZapLevel(logLevel string) zapcore.Level {
 	switch logLevel {
 	case "debug":
@@ -33,7 +64,7 @@ func NewLogger(logLevel string) *zap.Logger {
 		DisableCaller:     true,
 		DisableStacktrace: true,
 		Sampling:          nil,
-		Encoding:          "console",
+		Encoding:          "redacted-console",
 		EncoderConfig:     encoderCfg,
 		OutputPaths: []string{
 			"stderr",
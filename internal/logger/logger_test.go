@@ -0,0 +1,28 @@
+package logger
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/magec/nomad-packfile/internal/redact"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestRedactingEncoder(t *testing.T) {
+	redact.Add("my-nomad-token")
+	encoder := redactingEncoder{zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())}.Clone()
+	encoder.AddString("token", "my-nomad-token")
+
+	buf, err := encoder.EncodeEntry(zapcore.Entry{Message: "using my-nomad-token"}, []zapcore.Field{zap.String("other", "my-nomad-token")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if strings.Contains(buf.String(), "my-nomad-token") {
+		t.Errorf("expected token to be redacted, got %q", buf.String())
+	}
+	if strings.Count(buf.String(), redact.Mask) != 3 {
+		t.Errorf("expected three redacted values, got %q", buf.String())
+	}
+}
@@ -0,0 +1,14 @@
+package nomadpack
+
+// Cluster performs the operations nomad-pack does not provide (following deployments, reverting
+// jobs, fetching their status) directly against the Nomad API.
+type Cluster struct {
+	connection Connection
+	label      string
+}
+
+// NewCluster returns a Cluster reached with connection. Its output is prefixed with label, like the
+// nomad-pack output of the release it works for.
+func NewCluster(connection Connection, label string) *Cluster {
+	return &Cluster{connection: connection, label: label}
+}
@@ -0,0 +1,60 @@
+package nomadpack
+
+import (
+	nomad "github.com/hashicorp/nomad/api"
+)
+
+// Connection holds the settings needed to reach a Nomad cluster. They are passed both to
+// the nomad-pack process (as NOMAD_* variables) and to the Nomad API client.
+type Connection struct {
+	Address       string
+	Token         string
+	Namespace     string
+	Region        string
+	CACert        string
+	ClientCert    string
+	ClientKey     string
+	TLSServerName string
+	SkipVerify    bool
+}
+
+// Env returns the NOMAD_* environment variables for the connection, unset settings are omitted.
+func (connection Connection) Env() []string {
+	env := []string{}
+	for name, value := range map[string]string{
+		"NOMAD_ADDR":            connection.Address,
+		"NOMAD_TOKEN":           connection.Token,
+		"NOMAD_NAMESPACE":       connection.Namespace,
+		"NOMAD_REGION":          connection.Region,
+		"NOMAD_CACERT":          connection.CACert,
+		"NOMAD_CLIENT_CERT":     connection.ClientCert,
+		"NOMAD_CLIENT_KEY":      connection.ClientKey,
+		"NOMAD_TLS_SERVER_NAME": connection.TLSServerName,
+	} {
+		if value != "" {
+			env = append(env, name+"="+value)
+		}
+	}
+	if connection.SkipVerify {
+		env = append(env, "NOMAD_SKIP_VERIFY=true")
+	}
+
+	return env
+}
+
+// APIConfig returns the configuration for a Nomad API client using the connection.
+func (connection Connection) APIConfig() *nomad.Config {
+	return &nomad.Config{
+		Address:   connection.Address,
+		SecretID:  connection.Token,
+		Namespace: connection.Namespace,
+		Region:    connection.Region,
+		TLSConfig: &nomad.TLSConfig{
+			CACert:        connection.CACert,
+			ClientCert:    connection.ClientCert,
+			ClientKey:     connection.ClientKey,
+			TLSServerName: connection.TLSServerName,
+			Insecure:      connection.SkipVerify,
+		},
+	}
+}
@@ -0,0 +1,34 @@
+package nomadpack
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestConnectionEnv(t *testing.T) {
+	connection := Connection{
+		Address:    "https://nomad:4646",
+		Token:      "token",
+		Namespace:  "apps",
+		CACert:     "/certs/ca.pem",
+		SkipVerify: true,
+	}
+
+	env := connection.Env()
+	slices.Sort(env)
+	expected := []string{
+		"NOMAD_ADDR=https://nomad:4646",
+		"NOMAD_CACERT=/certs/ca.pem",
+		"NOMAD_NAMESPACE=apps",
+		"NOMAD_SKIP_VERIFY=true",
+		"NOMAD_TOKEN=token",
+	}
+	if !slices.Equal(env, expected) {
+		t.Errorf("expected %v, got %v", expected, env)
+	}
+
+	config := connection.APIConfig()
+	if config.Namespace != "apps" || config.TLSConfig.CACert != "/certs/ca.pem" || !config.TLSConfig.Insecure {
+		t.Errorf("unexpected api config %+v", config)
+	}
+}
@@ -0,0 +1,159 @@
+package nomadpack
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/pterm/pterm"
+)
+
+// Statuses of a deployment, the ones reported by Nomad plus the ones set by WaitForDeployments.
+const (
+	DeploymentStatusSuccessful = "successful"
+	DeploymentStatusFailed     = "failed"
+	DeploymentStatusCancelled  = "cancelled"
+	// DeploymentStatusTimeout is set when the deployment did not finish in time.
+	DeploymentStatusTimeout = "timeout"
+	// DeploymentStatusNone is set for jobs that do not create deployments (batch, system...).
+	DeploymentStatusNone = "none"
+)
+
+var (
+	// deploymentPollInterval is how often the deployment status is fetched.
+	deploymentPollInterval = 2 * time.Second
+	// deploymentGracePeriod is how long to wait for Nomad to create the deployment of a new job version
+	// before assuming the job does not have one.
+	deploymentGracePeriod = 30 * time.Second
+)
+
+// DeploymentResult is the outcome of waiting for the deployment of a job.
+type DeploymentResult struct {
+	JobID        string `json:"job_id"`
+	Namespace    string `json:"namespace,omitempty"`
+	JobVersion   uint64 `json:"job_version"`
+	DeploymentID string `json:"deployment_id,omitempty"`
+	Status       string `json:"status"`
+	Description  string `json:"description,omitempty"`
+	// RolledBackTo is the version the job was reverted to after the deployment failed, if any.
+	RolledBackTo *uint64 `json:"rolled_back_to,omitempty"`
+}
+
+// Job returns the ID of the deployed job.
+func (result DeploymentResult) Job() JobID {
+	return JobID{Namespace: result.Namespace, ID: result.JobID}
+}
+
+// Healthy returns whether the job was deployed successfully.
+func (result DeploymentResult) Healthy() bool {
+	return result.Status == DeploymentStatusSuccessful || result.Status == DeploymentStatusNone
+}
+
+// WaitForDeployments follows the latest deployment of every job until it finishes or the timeout
+// (shared by all the jobs) expires, showing the allocation status while it runs.
+func (cluster *Cluster) WaitForDeployments(jobIDs []JobID, timeout time.Duration) ([]DeploymentResult, error) {
+	client, err := nomad.NewClient(cluster.connection.APIConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	results := []DeploymentResult{}
+	for _, jobID := range jobIDs {
+		result, err := cluster.waitForDeployment(client, jobID, deadline)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (cluster *Cluster) waitForDeployment(client *nomad.Client, jobID JobID, deadline time.Time) (DeploymentResult, error) {
+	result := DeploymentResult{JobID: jobID.ID, Namespace: jobID.Namespace}
+	options := jobID.queryOptions()
+	job, _, err := client.Jobs().Info(jobID.ID, options)
+	if err != nil {
+		return result, fmt.Errorf("could not fetch job %s: %w", jobID, err)
+	}
+	result.JobVersion = *job.Version
+	if job.Type != nil && *job.Type != "service" {
+		result.Status = DeploymentStatusNone
+		return result, nil
+	}
+
+	graceDeadline := time.Now().Add(deploymentGracePeriod)
+	lastStatus := ""
+	for {
+		deployment, _, err := client.Jobs().LatestDeployment(jobID.ID, options)
+		if err != nil {
+			return result, fmt.Errorf("could not fetch the deployment of job %s: %w", jobID, err)
+		}
+
+		if deployment == nil || deployment.JobVersion != result.JobVersion {
+			if time.Now().After(graceDeadline) {
+				result.Status = DeploymentStatusNone
+				return result, nil
+			}
+		} else {
+			result.DeploymentID = deployment.ID
+			result.Status = deployment.Status
+			result.Description = deployment.StatusDescription
+
+			status := cluster.deploymentStatusLine(client, deployment, options)
+			if status != lastStatus {
+				cluster.printLine(status)
+				lastStatus = status
+			}
+
+			if slices.Contains([]string{DeploymentStatusSuccessful, DeploymentStatusFailed, DeploymentStatusCancelled}, deployment.Status) {
+				return result, nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			result.Status = DeploymentStatusTimeout
+			result.Description = "Deployment did not finish in time"
+			return result, nil
+		}
+		time.Sleep(deploymentPollInterval)
+	}
+}
+
+// deploymentStatusLine summarizes the deployment and its allocations, e.g.
+// job app v3 deployment running: web 1/2 healthy; allocations: 2 running.
+func (cluster *Cluster) deploymentStatusLine(client *nomad.Client, deployment *nomad.Deployment, options *nomad.QueryOptions) string {
+	groups := []string{}
+	for name, state := range deployment.TaskGroups {
+		groups = append(groups, fmt.Sprintf("%s %d/%d healthy", name, state.HealthyAllocs, state.DesiredTotal))
+	}
+	slices.Sort(groups)
+	line := fmt.Sprintf("job %s v%d deployment %s: %s", deployment.JobID, deployment.JobVersion, deployment.Status, strings.Join(groups, ", "))
+
+	allocations, _, err := client.Deployments().Allocations(deployment.ID, options)
+	if err == nil && len(allocations) > 0 {
+		byStatus := map[string]int{}
+		for _, allocation := range allocations {
+			byStatus[allocation.ClientStatus]++
+		}
+		statuses := []string{}
+		for status, count := range byStatus {
+			statuses = append(statuses, fmt.Sprintf("%d %s", count, status))
+		}
+		slices.Sort(statuses)
+		line += "; allocations: " + strings.Join(statuses, ", ")
+	}
+
+	return line
+}
+
+// printLine prints line prefixed with the label, like the nomad-pack output.
+func (cluster *Cluster) printLine(line string) {
+	if cluster.label != "" {
+		line = "[" + cluster.label + "] " + line
+	}
+	pterm.Println(line)
+}
@@ -0,0 +1,58 @@
+package nomadpack
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DefaultEnvPassthrough are the variables always passed to nomad-pack unless explicitly denied.
+var DefaultEnvPassthrough = []string{"HOME", "TERM", "PATH"}
+
+// EnvPassthrough selects which variables of the nomad-packfile environment are passed to nomad-pack.
+// Allow and Deny are glob patterns (e.g. NOMAD_PACK_*), a variable is passed when it matches
+// any Allow pattern or DefaultEnvPassthrough, and none of the Deny ones.
+type EnvPassthrough struct {
+	Allow []string
+	Deny  []string
+}
+
+// Validate returns an error if any of the patterns is malformed.
+func (passthrough EnvPassthrough) Validate() error {
+	for _, pattern := range append(passthrough.Allow, passthrough.Deny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid env-passthrough pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Merge returns the union of both passthrough settings.
+func (passthrough EnvPassthrough) Merge(other EnvPassthrough) EnvPassthrough {
+	return EnvPassthrough{
+		Allow: append(append([]string{}, passthrough.Allow...), other.Allow...),
+		Deny:  append(append([]string{}, passthrough.Deny...), other.Deny...),
+	}
+}
+
+// Filter returns the entries of environ (in the form KEY=value) that are passed through.
+func (passthrough EnvPassthrough) Filter(environ []string) []string {
+	allow := append(append([]string{}, DefaultEnvPassthrough...), passthrough.Allow...)
+	env := []string{}
+	for _, entry := range environ {
+		name, _, _ := strings.Cut(entry, "=")
+		if matchesAny(name, allow) && !matchesAny(name, passthrough.Deny) {
+			env = append(env, entry)
+		}
+	}
+	return env
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package nomadpack
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEnvPassthroughFilter(t *testing.T) {
+	environ := []string{
+		"HOME=/home/user",
+		"PATH=/usr/bin",
+		"TERM=xterm",
+		"SSH_AUTH_SOCK=/tmp/agent.sock",
+		"NOMAD_PACK_CACHE=/cache",
+		"NOMAD_PACK_SECRET=hidden",
+		"AWS_SECRET_ACCESS_KEY=hidden",
+	}
+
+	passthrough := EnvPassthrough{Allow: []string{"SSH_AUTH_SOCK"}}.Merge(EnvPassthrough{Allow: []string{"NOMAD_PACK_*"}, Deny: []string{"*_SECRET", "TERM"}})
+	env := passthrough.Filter(environ)
+
+	expected := []string{"HOME=/home/user", "PATH=/usr/bin", "SSH_AUTH_SOCK=/tmp/agent.sock", "NOMAD_PACK_CACHE=/cache"}
+	if !slices.Equal(env, expected) {
+		t.Errorf("expected %v, got %v", expected, env)
+	}
+}
+
+func TestEnvPassthroughValidate(t *testing.T) {
+	if err := (EnvPassthrough{Allow: []string{"NOMAD_PACK_*"}}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (EnvPassthrough{Deny: []string{"[INVALID"}}).Validate(); err == nil {
+		t.Errorf("expected an error for an invalid pattern")
+	}
+}
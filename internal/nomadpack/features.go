@@ -0,0 +1,30 @@
+package nomadpack
+
+import "fmt"
+
+// feature is a nomad-pack flag that is only available since a given version.
+type feature struct {
+	flag  string
+	since Version
+}
+
+// Version dependent flags, since the oldest release known to support them.
+var (
+	featureExitCodeMakesChanges = feature{flag: "--exit-code-makes-changes", since: Version{Minor: 1}}
+	featureRenderToDir          = feature{flag: "--to-dir", since: Version{Minor: 1}}
+	featureRef                  = feature{flag: "--ref", since: Version{Minor: 1}}
+)
+
+// supports returns whether the nomad-pack binary supports the feature. When its version is
+// unknown every feature is assumed to be supported.
+func (nomadPack *NomadPack) supports(feature feature) bool {
+	return nomadPack.version == nil || nomadPack.version.Compare(feature.since) >= 0
+}
+
+// requires returns an error if the nomad-pack binary does not support the feature.
+func (nomadPack *NomadPack) requires(feature feature) error {
+	if nomadPack.supports(feature) {
+		return nil
+	}
+	return fmt.Errorf("nomad-pack %s does not support %s, nomad-pack >= %s is needed", nomadPack.version, feature.flag, feature.since)
+}
@@ -0,0 +1,112 @@
+package nomadpack
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	nomad "github.com/hashicorp/nomad/api"
+)
+
+// JobID identifies a job in Nomad.
+type JobID struct {
+	// Namespace is the namespace set by the job, empty for the namespace of the connection.
+	Namespace string
+	ID        string
+}
+
+func (id JobID) String() string {
+	if id.Namespace == "" {
+		return id.ID
+	}
+	return id.Namespace + "/" + id.ID
+}
+
+// queryOptions returns the options to read the job, nil to use the namespace of the connection.
+func (id JobID) queryOptions() *nomad.QueryOptions {
+	if id.Namespace == "" {
+		return nil
+	}
+	return &nomad.QueryOptions{Namespace: id.Namespace}
+}
+
+// writeOptions returns the options to update the job, nil to use the namespace of the connection.
+func (id JobID) writeOptions() *nomad.WriteOptions {
+	if id.Namespace == "" {
+		return nil
+	}
+	return &nomad.WriteOptions{Namespace: id.Namespace}
+}
+
+// renderedTemplate matches the lines nomad-pack render prints before the content of every
+// template, e.g. app/templates/app.nomad.tpl:
+var renderedTemplate = regexp.MustCompile(`(?m)^(\S+\.tpl):$`)
+
+// jobTemplateSuffix is the suffix of the templates nomad-pack renders as jobs, other templates,
+// like a README or the outputs, are not HCL.
+const jobTemplateSuffix = ".nomad.tpl"
+
+// ParseJobIDs returns the IDs of the jobs defined in the output of nomad-pack render. Every job
+// template is parsed by the Nomad cluster, so its id and namespace are the ones Nomad registers.
+func (cluster *Cluster) ParseJobIDs(rendered string) ([]JobID, error) {
+	client, err := nomad.NewClient(cluster.connection.APIConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []JobID{}
+	seen := map[JobID]bool{}
+	bounds := renderedTemplate.FindAllStringSubmatchIndex(rendered, -1)
+	for i, bound := range bounds {
+		name := rendered[bound[2]:bound[3]]
+		end := len(rendered)
+		if i+1 < len(bounds) {
+			end = bounds[i+1][0]
+		}
+		content := rendered[bound[1]:end]
+		// A job template may render nothing, e.g. when the job is disabled by a variable.
+		if !strings.HasSuffix(name, jobTemplateSuffix) || strings.TrimSpace(content) == "" {
+			continue
+		}
+
+		job, err := client.Jobs().ParseHCLOpts(&nomad.JobsParseRequest{JobHCL: content})
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the job of template %s: %w", name, err)
+		}
+		id := JobID{}
+		if job.ID != nil && *job.ID != "" {
+			id.ID = *job.ID
+		} else if job.Name != nil {
+			id.ID = *job.Name
+		}
+		if id.ID == "" {
+			return nil, fmt.Errorf("the job of template %s has no ID", name)
+		}
+		if job.Namespace != nil {
+			id.Namespace = *job.Namespace
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// JobIDs renders the pack without showing its output and returns the IDs of the jobs it defines.
+func (nomadPack *NomadPack) JobIDs(invocation Invocation) ([]JobID, error) {
+	invocation.ToDir = ""
+	cmd, err := nomadPack.packCommand(invocation, "render")
+	if err != nil {
+		return nil, err
+	}
+
+	quiet := *nomadPack
+	quiet.quiet = true
+	result, err := quiet.runCommand(cmd, invocation)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCluster(invocation.Connection, "").ParseJobIDs(result.Stdout)
+}
@@ -0,0 +1,77 @@
+package nomadpack
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/magec/nomad-packfile/test/nomadtest"
+)
+
+func TestParseJobIDs(t *testing.T) {
+	server := nomadtest.NewServer(t)
+	rendered := `app/templates/app.nomad.tpl:
+
+job "app" {
+  type = "service"
+}
+
+app/templates/worker.nomad.tpl:
+
+job "app-worker" {
+  namespace = "workers"
+}
+
+app/templates/batch.nomad.tpl:
+
+job "batch" {
+  id = "nightly-batch"
+}
+
+app/templates/disabled.nomad.tpl:
+
+
+app/templates/README.md.tpl:
+
+Don't forget to "quote { things
+
+app/templates/outputs.tpl:
+
+job "app" {
+}
+`
+	ids, err := NewCluster(Connection{Address: server.URL}, "").ParseJobIDs(rendered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []JobID{{ID: "app"}, {Namespace: "workers", ID: "app-worker"}, {ID: "nightly-batch"}}
+	if !slices.Equal(ids, expected) {
+		t.Errorf("expected %v, got %v", expected, ids)
+	}
+
+	parses := 0
+	for _, request := range server.Requests() {
+		if request == "PUT /v1/jobs/parse" {
+			parses++
+		}
+	}
+	if parses != 3 {
+		t.Errorf("expected only the job templates to be parsed, got %d parses", parses)
+	}
+}
+
+func TestParseJobIDsErrors(t *testing.T) {
+	server := nomadtest.NewServer(t)
+	cluster := NewCluster(Connection{Address: server.URL}, "")
+
+	_, err := cluster.ParseJobIDs("app/templates/app.nomad.tpl:\n\njob \"app\" {\n  id = \"${var.id}\"\n}\n")
+	if err == nil || !strings.Contains(err.Error(), "could not parse the job of template app/templates/app.nomad.tpl") {
+		t.Errorf("expected the error of Nomad instead of the label of the job, got %v", err)
+	}
+
+	server.Denied = []string{"/v1/jobs/parse"}
+	_, err = cluster.ParseJobIDs("app/templates/app.nomad.tpl:\n\njob \"app\" {\n}\n")
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected the parse to fail, got %v", err)
+	}
+}
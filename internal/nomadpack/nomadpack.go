@@ -1,141 +1,264 @@
 package nomadpack
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"slices"
+	"sync"
+	"time"
 
-	nomad "github.com/hashicorp/nomad/api"
 	"github.com/pterm/pterm"
 	"go.uber.org/zap"
 )
 
-// NomadPack is a wrapper around the Nomad binary that provides a way to interact with Nomad using the Nomad Pack CLI.
+// planExitCodeChanges is the exit code nomad-pack is told to use when a plan would change the cluster.
+const planExitCodeChanges = 2
+
+// planDefaultExitCodeChanges is the exit code of a plan that would change the cluster when nomad-pack
+// does not support choosing it.
+const planDefaultExitCodeChanges = 1
+
+// Result holds the outcome of a nomad-pack invocation.
+type Result struct {
+	Command  string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Duration time.Duration
+	// Changes is set by Plan when the plan would modify the cluster.
+	Changes bool
+	// Deployments holds the deployments followed after a run, if any.
+	Deployments []DeploymentResult
+}
+
+// NomadPack is the Runner running the nomad-pack binary.
 type NomadPack struct {
-	binaryPath string
-	logger     *zap.Logger
-	nomadAddr  string
-	nomadToken string
+	binaryPath     string
+	logger         *zap.Logger
+	envPassthrough EnvPassthrough
+	quiet          bool
+	// version is the version of the binary, nil when it could not be detected.
+	version *Version
 }
 
+var _ Runner = (*NomadPack)(nil)
+
 // Creates a new NomadPack instance by providing the path to the Nomad binary.
-// If the binary is not found or is not executable an error is returned.
-func New(binaryPath string, logger *zap.Logger) (*NomadPack, error) {
+// If the binary is not found or is not executable an error is returned. The version of the binary is
+// detected to know which flags it supports, and checked against versionConstraint (see
+// ParseConstraint) when it is not empty.
+func New(binaryPath, versionConstraint string, logger *zap.Logger) (*NomadPack, error) {
 	binaryPath, err := exec.LookPath(binaryPath)
 	if err != nil {
 		return nil, err
 	}
 
-	return &NomadPack{binaryPath: binaryPath, logger: logger}, nil
+	nomadPack := &NomadPack{binaryPath: binaryPath, logger: logger}
+	err = nomadPack.detectVersion(versionConstraint)
+	if err != nil {
+		return nil, err
+	}
+	return nomadPack, nil
+}
+
+// detectVersion runs nomad-pack version and checks the version against versionConstraint. If the
+// version cannot be detected and there is no constraint, every feature is assumed to be supported.
+func (nomadPack *NomadPack) detectVersion(versionConstraint string) error {
+	var constraint Constraint
+	if versionConstraint != "" {
+		var err error
+		constraint, err = ParseConstraint(versionConstraint)
+		if err != nil {
+			return fmt.Errorf("invalid nomad-pack-version: %w", err)
+		}
+	}
+
+	cmd := exec.Command(nomadPack.binaryPath, "version")
+	cmd.Env = EnvPassthrough{}.Filter(os.Environ())
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		var version Version
+		version, err = ParseVersionOutput(string(output))
+		if err == nil {
+			nomadPack.version = &version
+		}
+	}
+
+	if err != nil {
+		if versionConstraint != "" {
+			return fmt.Errorf("could not detect the version of %s to check nomad-pack-version %q: %w", nomadPack.binaryPath, versionConstraint, err)
+		}
+		nomadPack.logger.Warn("Could not detect the nomad-pack version, assuming every flag is supported", zap.String("binary", nomadPack.binaryPath), zap.Error(err))
+		return nil
+	}
+
+	nomadPack.logger.Debug("Detected nomad-pack version", zap.String("binary", nomadPack.binaryPath), zap.Stringer("version", nomadPack.version))
+	if versionConstraint != "" && !constraint.Check(*nomadPack.version) {
+		return fmt.Errorf("%s is nomad-pack %s, which does not meet the nomad-pack-version constraint %q", nomadPack.binaryPath, nomadPack.version, versionConstraint)
+	}
+	return nil
 }
 
-func (nomadPack *NomadPack) NomadAddr(nomad_addr string) *NomadPack {
-	nomadPack.nomadAddr = nomad_addr
+// EnvPassthrough sets which variables of the current environment are passed to nomad-pack when it
+// is not run for a release, e.g. to list registries, and when adding registries.
+func (nomadPack *NomadPack) EnvPassthrough(envPassthrough EnvPassthrough) *NomadPack {
+	nomadPack.envPassthrough = envPassthrough
 	return nomadPack
 }
 
-func (nomadPack *NomadPack) NomadToken(nomad_token string) *NomadPack {
-	nomadPack.nomadToken = nomad_token
+// Quiet makes nomad-pack output to be shown only when the command fails.
+func (nomadPack *NomadPack) Quiet(quiet bool) *NomadPack {
+	nomadPack.quiet = quiet
 	return nomadPack
 }
 
-// Add nomad pack registries
-// name: the name of the registry.
-// source: the source of the registry.
-// ref: speficic git ref of the registry or pack to be added.
-// target: A specific pack within the registry to be added.
-func (nomadPack *NomadPack) AddRegistry(name, source string, ref, target *string) error {
-	params := []string{"registry", "add", name, source}
-	if ref != nil {
+// AddRegistry runs nomad-pack registry add, its output is labeled registry/<name>.
+func (nomadPack *NomadPack) AddRegistry(registry Registry) error {
+	params := []string{"registry", "add", registry.Name, registry.URL}
+	if registry.Ref != nil {
+		err := nomadPack.requires(featureRef)
+		if err != nil {
+			return err
+		}
 		params = append(params, "--ref")
-		params = append(params, *ref)
+		params = append(params, *registry.Ref)
 	}
 
-	if target != nil {
+	if registry.Target != nil {
 		params = append(params, "--target")
-		params = append(params, *target)
+		params = append(params, *registry.Target)
 	}
 
-	pterm.DefaultBasicText.Println("Adding registry", name, source)
+	pterm.DefaultBasicText.Println("Adding registry", registry.Name, registry.URL)
 	cmd := exec.Command(nomadPack.binaryPath, params...)
 
-	_, err := nomadPack.runCommand(cmd)
-	if err != nil {
+	invocation := Invocation{
+		EnvPassthrough: nomadPack.envPassthrough.Merge(registry.EnvPassthrough),
+		Env:            registry.Env,
+		Label:          "registry/" + registry.Name,
+	}
+	_, err := nomadPack.runCommand(cmd, invocation)
+	if err == nil {
 		pterm.DefaultBasicText.Println("Successfully added.")
 	}
 	return err
 }
 
-// Plan runs the Nomad Pack plan command.
-// workDir: the directory nomad-pack will be run in.
-// diff: whether to show the diff.
-// ref: speficic git ref of the registry or pack to be added.
-// registry: the registry to use.
-// pack: the pack to run.
-// varFiles: an array of var files to use.
-// vars: a map of vars to use.
-func (nomadPack *NomadPack) Plan(workDir string, diff bool, varFiles []string, vars map[string]string, extraParams []string) error {
-	err := nomadPack.ensureValidAuth()
+// Plan runs the Nomad Pack plan command showing the diff.
+// The returned result has Changes set when the plan would modify the cluster.
+func (nomadPack *NomadPack) Plan(invocation Invocation) (*Result, error) {
+	params := []string{"plan", "--diff"}
+	exitCodeChanges := planDefaultExitCodeChanges
+	if nomadPack.supports(featureExitCodeMakesChanges) {
+		params = append(params, fmt.Sprintf("%s=%d", featureExitCodeMakesChanges.flag, planExitCodeChanges))
+		exitCodeChanges = planExitCodeChanges
+	}
+	cmd, err := nomadPack.packCommand(invocation, params...)
 	if err != nil {
-		pterm.Error.Printf("Could not connect to Nomad Server: %v\n", err)
-		return err
+		return nil, err
 	}
-	params := []string{"plan", "--diff", "--exit-code-makes-changes=0"}
 
-	for _, varFile := range varFiles {
-		params = append(params, "-var-file")
-		params = append(params, varFile)
+	pterm.DefaultBasicText.Println("Running Plan.")
+	result, err := nomadPack.runCommand(cmd, invocation, exitCodeChanges)
+	if err == nil {
+		result.Changes = result.ExitCode == exitCodeChanges
+		pterm.DefaultBasicText.Println("Plan successfully ran.")
 	}
+	return result, err
+}
 
-	for key, value := range vars {
-		params = append(params, "-var")
-		params = append(params, key+"="+value)
+// Run runs the Nomad Pack run command.
+func (nomadPack *NomadPack) Run(invocation Invocation) (*Result, error) {
+	cmd, err := nomadPack.packCommand(invocation, "run")
+	if err != nil {
+		return nil, err
 	}
-	params = append(params, extraParams...)
 
-	cmd := exec.Command(nomadPack.binaryPath, params...)
-	cmd.Dir = workDir
+	pterm.DefaultBasicText.Println("Running Run.")
+	result, err := nomadPack.runCommand(cmd, invocation)
+	if err == nil {
+		pterm.DefaultBasicText.Println("Run successfully ran.")
+	}
+	return result, err
+}
 
-	pterm.DefaultBasicText.Println("Running Plan.")
-	stdout, err := nomadPack.runCommand(cmd)
+// Render runs the Nomad Pack render command. When invocation.ToDir is not empty, the rendered
+// templates are written to it instead of being printed.
+func (nomadPack *NomadPack) Render(invocation Invocation) (*Result, error) {
+	params := []string{"render"}
+	if invocation.ToDir != "" {
+		err := nomadPack.requires(featureRenderToDir)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, "--to-dir")
+		params = append(params, invocation.ToDir)
+	}
+	cmd, err := nomadPack.packCommand(invocation, params...)
 	if err != nil {
-		pterm.DefaultBasicText.Println("Plan successfully ran.")
+		return nil, err
 	}
-	pterm.Println(stdout)
-	return err
+
+	pterm.DefaultBasicText.Println("Running Render.")
+	result, err := nomadPack.runCommand(cmd, invocation)
+	if err == nil {
+		pterm.DefaultBasicText.Println("Render successfully ran.")
+	}
+
+	return result, err
 }
 
-func (nomadPack *NomadPack) Run(workDir string, diff bool, varFiles []string, vars map[string]string, extraParams []string) error {
-	err := nomadPack.ensureValidAuth()
+// Destroy runs the Nomad Pack destroy command, which stops and purges the jobs of the pack.
+func (nomadPack *NomadPack) Destroy(invocation Invocation) (*Result, error) {
+	cmd, err := nomadPack.packCommand(invocation, "destroy")
 	if err != nil {
-		pterm.Error.Printf("Could not connect to Nomad Server: %v\n", err)
-		return err
+		return nil, err
 	}
-	params := []string{"run"}
-	for _, varFile := range varFiles {
-		params = append(params, "-var-file")
-		params = append(params, varFile)
+
+	pterm.DefaultBasicText.Println("Running Destroy.")
+	result, err := nomadPack.runCommand(cmd, invocation)
+	if err == nil {
+		pterm.DefaultBasicText.Println("Destroy successfully ran.")
 	}
+	return result, err
+}
 
-	for key, value := range vars {
-		params = append(params, "-var")
-		params = append(params, key+"="+value)
+// packCommand returns the nomad-pack command with params for the pack, vars and var files of
+// invocation, run in invocation.WorkDir.
+func (nomadPack *NomadPack) packCommand(invocation Invocation, params ...string) (*exec.Cmd, error) {
+	if invocation.Pack.Ref != "" {
+		err := nomadPack.requires(featureRef)
+		if err != nil {
+			return nil, err
+		}
 	}
-	params = append(params, extraParams...)
+	params = append(params, varParams(invocation.VarFiles, invocation.Vars)...)
+	params = append(params, packParams(invocation.Pack)...)
+
 	cmd := exec.Command(nomadPack.binaryPath, params...)
-	cmd.Dir = workDir
+	cmd.Dir = invocation.WorkDir
+	return cmd, nil
+}
 
-	pterm.DefaultBasicText.Println("Running Run.")
-	_, err = nomadPack.runCommand(cmd)
-	if err != nil {
-		pterm.DefaultBasicText.Println("Run successfully ran.")
+// packParams returns the nomad-pack parameters selecting pack.
+func packParams(pack Pack) (params []string) {
+	if pack.Registry != "" {
+		params = append(params, "--registry")
+		params = append(params, pack.Registry)
+		if pack.Ref != "" {
+			params = append(params, "--ref")
+			params = append(params, pack.Ref)
+		}
 	}
-	return err
+	params = append(params, pack.Name)
+
+	return params
 }
 
-func (nomadPack *NomadPack) Render(workDir string, diff bool, varFiles []string, vars map[string]string, extraParams []string) error {
-	params := []string{"render"}
+// varParams returns the nomad-pack parameters for the given var files and vars.
+func varParams(varFiles []string, vars map[string]string) (params []string) {
 	for _, varFile := range varFiles {
 		params = append(params, "-var-file")
 		params = append(params, varFile)
@@ -146,67 +269,64 @@ func (nomadPack *NomadPack) Render(workDir string, diff bool, varFiles []string,
 		params = append(params, key+"="+value)
 	}
 
-	params = append(params, extraParams...)
-	cmd := exec.Command(nomadPack.binaryPath, params...)
-	cmd.Dir = workDir
-
-	pterm.DefaultBasicText.Println("Running Render.")
-	stdout, err := nomadPack.runCommand(cmd)
-	if err != nil {
-		pterm.DefaultBasicText.Println("Render successfully ran.")
-	}
-	pterm.Println(stdout)
-
-	return err
+	return params
 }
 
-func (nomadPack *NomadPack) envForCommand() []string {
-	var env = []string{}
+// envForCommand builds the nomad-pack environment: the passed through variables, then the extra
+// ones and finally the Nomad connection settings. Later entries override earlier ones.
+func envForCommand(invocation Invocation) []string {
+	env := invocation.EnvPassthrough.Filter(os.Environ())
 
-	// Nomad credentials
-	if nomadPack.nomadAddr != "" {
-		env = append(env, "NOMAD_ADDR="+nomadPack.nomadAddr)
-	}
-	if nomadPack.nomadToken != "" {
-		env = append(env, "NOMAD_TOKEN="+nomadPack.nomadToken)
+	for key, value := range invocation.Env {
+		env = append(env, key+"="+value)
 	}
 
-	env = append(env, "HOME="+os.Getenv("HOME"))
-	env = append(env, "TERM="+os.Getenv("TERM"))
-	env = append(env, "PATH="+os.Getenv("PATH"))
+	// Nomad connection settings
+	env = append(env, invocation.Connection.Env()...)
 
 	return env
 }
 
-func (nomadPack *NomadPack) runCommand(cmd *exec.Cmd) (string, error) {
-	cmd.Env = append(cmd.Env, nomadPack.envForCommand()...)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		pterm.Error.Println("Error running command")
-		pterm.Error.Println("Command:", cmd.String())
-		pterm.Error.Println(stdout.String())
-		pterm.Error.Println(stderr.String())
-		return stdout.String(), err
+// runCommand runs cmd with the environment and output label of invocation, streaming its output
+// line by line (unless quiet) while collecting it.
+// Exit codes listed in acceptedExitCodes are not considered failures, they are reported in Result.ExitCode.
+func (nomadPack *NomadPack) runCommand(cmd *exec.Cmd, invocation Invocation, acceptedExitCodes ...int) (*Result, error) {
+	cmd.Env = append(cmd.Env, envForCommand(invocation)...)
+	var mu sync.Mutex
+	printLine := func(line string) { pterm.Println(line) }
+	stdout := newLineWriter(invocation.Label, nomadPack.quiet, printLine, &mu)
+	stderr := newLineWriter(invocation.Label, nomadPack.quiet, printLine, &mu)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	stdout.Flush()
+	stderr.Flush()
+	result := &Result{
+		Command:  cmd.String(),
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		Duration: time.Since(start),
 	}
 
-	return stdout.String(), nil
-}
-
-func (nomadPack *NomadPack) ensureValidAuth() error {
-	if nomadPack.nomadAddr == "" {
-		return fmt.Errorf("Nomad address is required")
-	}
-	nomadClient, err := nomad.NewClient(&nomad.Config{Address: nomadPack.nomadAddr, SecretID: nomadPack.nomadToken})
-	if err != nil {
-		return err
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+		if slices.Contains(acceptedExitCodes, result.ExitCode) {
+			err = nil
+		}
 	}
-	_, err = nomadClient.Status().Peers()
+
 	if err != nil {
-		return fmt.Errorf("Could not connect to Nomad, error while trying to fetch then status of peers: %v", err)
+		pterm.Error.Println("Error running command")
+		pterm.Error.Println("Command:", cmd.String())
+		if nomadPack.quiet {
+			pterm.Error.Println(result.Stdout)
+			pterm.Error.Println(result.Stderr)
+		}
+		return result, err
 	}
 
-	return nil
+	return result, nil
 }
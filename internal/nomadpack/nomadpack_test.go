@@ -1,14 +1,16 @@
 package nomadpack
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/magec/nomad-packfile/test"
+	"github.com/magec/nomad-packfile/test/nomadtest"
 	"github.com/pterm/pterm"
 )
 
 func TestNomadPackNewWithInvalidPath(t *testing.T) {
-	_, err := New("I_DONT_EXISTS", test.GetLogger(t))
+	_, err := New("I_DONT_EXISTS", "", test.GetLogger(t))
 	if err == nil {
 		t.Fatal("Expected an error")
 	}
@@ -25,15 +27,51 @@ func TestNomadPackAddRegistryOk(t *testing.T) {
 	nomadPack := nomadPack(t)
 	main := "main"
 	alertmanager := "alertmanager"
-	err := nomadPack.AddRegistry("testing-community", "github.com/hashicorp/nomad-pack-community-registry", &main, &alertmanager)
+	err := nomadPack.AddRegistry(Registry{Name: "testing-community", URL: "github.com/hashicorp/nomad-pack-community-registry", Ref: &main, Target: &alertmanager})
 	if err != nil {
 		t.Fatalf("failed to add registry: %v", err)
 	}
 }
 
+func TestNomadPackAddRegistryEnv(t *testing.T) {
+	nomadPack := nomadPack(t)
+	registry := Registry{Name: "myorg", URL: "github.com/myorg/packs", Env: map[string]string{"FAKE_NOMAD_PACK_FAIL": "registry"}}
+	err := nomadPack.AddRegistry(registry)
+	if err == nil {
+		t.Error("expected the env of the registry to be passed to nomad-pack")
+	}
+
+	t.Setenv("FAKE_NOMAD_PACK_FAIL", "registry")
+	err = nomadPack.AddRegistry(Registry{Name: "myorg", URL: "github.com/myorg/packs"})
+	if err != nil {
+		t.Errorf("expected the variable not to be passed through by default, got %v", err)
+	}
+	err = nomadPack.AddRegistry(Registry{Name: "myorg", URL: "github.com/myorg/packs", EnvPassthrough: EnvPassthrough{Allow: []string{"FAKE_*"}}})
+	if err == nil {
+		t.Error("expected the env-passthrough of the registry to be applied")
+	}
+}
+
+func TestNomadPackRegistries(t *testing.T) {
+	nomadPack := nomadPack(t)
+	ref := "v1.0.0"
+	err := nomadPack.AddRegistry(Registry{Name: "myorg", URL: "github.com/myorg/packs", Ref: &ref})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registries, err := nomadPack.Registries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(registries) != 1 || registries[0].Name != "myorg" || *registries[0].Ref != ref || registries[0].URL != "github.com/myorg/packs" {
+		t.Errorf("expected myorg@v1.0.0 to be cached, got %+v", registries)
+	}
+}
+
 func TestNomadPackPlanWithoutCredentials(t *testing.T) {
 	nomadPack := nomadPack(t)
-	err := nomadPack.Plan("", true, "", "", "", nil, nil)
+	_, err := nomadPack.Plan(Invocation{})
 	if err == nil {
 		t.Fatal("Expected error while adding registry.")
 	}
@@ -41,16 +79,68 @@ func TestNomadPackPlanWithoutCredentials(t *testing.T) {
 
 func TestNomadPackAddRegistryFailed(t *testing.T) {
 	nomadPack := nomadPack(t)
-	err := nomadPack.AddRegistry("testing-community", "NO_URL", nil, nil)
+	err := nomadPack.AddRegistry(Registry{Name: "testing-community", URL: "NO_URL"})
 	if err == nil {
 		t.Fatal("Expected error while adding registry.")
 	}
 }
 
+func TestNomadPackVersionConstraint(t *testing.T) {
+	binaryPath := nomadtest.BuildNomadPack(t)
+
+	_, err := New(binaryPath, ">= 0.1.0, < 0.2.0", test.GetLogger(t))
+	if err != nil {
+		t.Fatalf("expected 0.1.2 to meet the constraint: %v", err)
+	}
+
+	_, err = New(binaryPath, "~> 0.2.0", test.GetLogger(t))
+	if err == nil || !strings.Contains(err.Error(), "0.1.2") {
+		t.Fatalf("expected an error naming the detected version, got %v", err)
+	}
+
+	_, err = New(binaryPath, "latest", test.GetLogger(t))
+	if err == nil {
+		t.Fatal("expected an error for an invalid constraint")
+	}
+}
+
+func TestNomadPackLegacyVersion(t *testing.T) {
+	pterm.DisableOutput()
+	binaryPath := nomadtest.BuildNomadPack(t)
+	nomadtest.SetNomadPackVersion(t, "0.0.1-techpreview3")
+	nomadPack, err := New(binaryPath, "", test.GetLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := nomadtest.NewServer(t)
+	invocation := Invocation{Pack: Pack{Name: "./packs/app"}, Connection: Connection{Address: server.URL}}
+
+	result, err := nomadPack.Plan(invocation)
+	if err != nil {
+		t.Fatalf("expected the plan to run without --exit-code-makes-changes: %v", err)
+	}
+	if !result.Changes {
+		t.Error("expected the default exit code to be read as changes")
+	}
+
+	invocation.Pack.Ref = "v1.0.0"
+	_, err = nomadPack.Run(invocation)
+	if err == nil || !strings.Contains(err.Error(), "--ref") {
+		t.Errorf("expected --ref to be rejected, got %v", err)
+	}
+
+	invocation.Pack.Ref = ""
+	invocation.ToDir = t.TempDir()
+	_, err = nomadPack.Render(invocation)
+	if err == nil || !strings.Contains(err.Error(), "--to-dir") {
+		t.Errorf("expected --to-dir to be rejected, got %v", err)
+	}
+}
+
 // helpers
 func nomadPack(t *testing.T) *NomadPack {
 	pterm.DisableOutput()
-	nomadPack, err := New(test.PathForAsset(t, "bin/nomad-pack"), test.GetLogger(t))
+	nomadPack, err := New(nomadtest.BuildNomadPack(t), "", test.GetLogger(t))
 	if err != nil {
 		t.Fatalf("failed to create nomad pack: %v", err)
 	}
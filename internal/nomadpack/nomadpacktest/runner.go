@@ -0,0 +1,170 @@
+// Package nomadpacktest provides an in-memory nomadpack.Runner to test code running nomad-pack
+// without the binary nor a Nomad cluster.
+package nomadpacktest
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/magec/nomad-packfile/internal/nomadpack"
+)
+
+// Operations recorded by Runner.
+const (
+	OperationAddRegistry = "add-registry"
+	OperationRegistries  = "registries"
+	OperationPlan        = "plan"
+	OperationRun         = "run"
+	OperationRender      = "render"
+	OperationDestroy     = "destroy"
+	OperationJobIDs      = "job-ids"
+)
+
+// Call is an operation received by Runner.
+type Call struct {
+	Operation string
+	// Registry is set for OperationAddRegistry.
+	Registry nomadpack.Registry
+	// Invocation is set for the operations on a pack.
+	Invocation nomadpack.Invocation
+	// VarFiles are the contents of the var files of the invocation, keyed by path, read when the
+	// call was received as some of them only exist while nomad-pack runs.
+	VarFiles map[string]string
+}
+
+// Label returns the label of the call: the one of the invocation, registry/<name> for registries
+// and registries when listing them.
+func (call Call) Label() string {
+	switch call.Operation {
+	case OperationAddRegistry:
+		return "registry/" + call.Registry.Name
+	case OperationRegistries:
+		return OperationRegistries
+	}
+	return call.Invocation.Label
+}
+
+// Runner is a nomadpack.Runner that records the calls it receives and answers them with the
+// configured results. Results, Errors and Jobs are keyed by label, e.g. production/app or
+// registry/myorg for registries.
+type Runner struct {
+	// Results are returned by Plan, Run, Render and Destroy, an empty result when missing.
+	Results map[string]nomadpack.Result
+	// Errors are returned by every operation of the label.
+	Errors map[string]error
+	// Jobs are returned by JobIDs.
+	Jobs map[string][]nomadpack.JobID
+	// Cached are the registries returned by Registries, AddRegistry appends to it.
+	Cached []nomadpack.Registry
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+var _ nomadpack.Runner = (*Runner)(nil)
+
+// New returns a Runner where every operation succeeds.
+func New() *Runner {
+	return &Runner{
+		Results: map[string]nomadpack.Result{},
+		Errors:  map[string]error{},
+		Jobs:    map[string][]nomadpack.JobID{},
+	}
+}
+
+// Calls returns the calls received so far, in order.
+func (runner *Runner) Calls() []Call {
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	return append([]Call{}, runner.calls...)
+}
+
+// CallsTo returns the calls received for operation, in order.
+func (runner *Runner) CallsTo(operation string) []Call {
+	calls := []Call{}
+	for _, call := range runner.Calls() {
+		if call.Operation == operation {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (runner *Runner) record(call Call) error {
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	runner.calls = append(runner.calls, call)
+	return runner.Errors[call.Label()]
+}
+
+func (runner *Runner) result(operation string, invocation nomadpack.Invocation) (*nomadpack.Result, error) {
+	err := runner.record(Call{Operation: operation, Invocation: invocation, VarFiles: readVarFiles(invocation)})
+	result := runner.Results[invocation.Label]
+	if result.Command == "" {
+		result.Command = "nomad-pack " + operation + " " + invocation.Pack.Name
+	}
+	if err != nil && result.ExitCode == 0 {
+		result.ExitCode = 1
+	}
+	return &result, err
+}
+
+func readVarFiles(invocation nomadpack.Invocation) map[string]string {
+	varFiles := map[string]string{}
+	for _, path := range invocation.VarFiles {
+		resolved := path
+		if !filepath.IsAbs(resolved) {
+			resolved = filepath.Join(invocation.WorkDir, path)
+		}
+		content, err := os.ReadFile(resolved)
+		if err == nil {
+			varFiles[path] = string(content)
+		}
+	}
+	return varFiles
+}
+
+func (runner *Runner) AddRegistry(registry nomadpack.Registry) error {
+	err := runner.record(Call{Operation: OperationAddRegistry, Registry: registry})
+	if err == nil {
+		runner.mu.Lock()
+		runner.Cached = append(runner.Cached, registry)
+		runner.mu.Unlock()
+	}
+	return err
+}
+
+func (runner *Runner) Registries() ([]nomadpack.Registry, error) {
+	err := runner.record(Call{Operation: OperationRegistries})
+	if err != nil {
+		return nil, err
+	}
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	return append([]nomadpack.Registry{}, runner.Cached...), nil
+}
+
+func (runner *Runner) Plan(invocation nomadpack.Invocation) (*nomadpack.Result, error) {
+	return runner.result(OperationPlan, invocation)
+}
+
+func (runner *Runner) Run(invocation nomadpack.Invocation) (*nomadpack.Result, error) {
+	return runner.result(OperationRun, invocation)
+}
+
+func (runner *Runner) Render(invocation nomadpack.Invocation) (*nomadpack.Result, error) {
+	return runner.result(OperationRender, invocation)
+}
+
+func (runner *Runner) Destroy(invocation nomadpack.Invocation) (*nomadpack.Result, error) {
+	return runner.result(OperationDestroy, invocation)
+}
+
+func (runner *Runner) JobIDs(invocation nomadpack.Invocation) ([]nomadpack.JobID, error) {
+	err := runner.record(Call{Operation: OperationJobIDs, Invocation: invocation, VarFiles: readVarFiles(invocation)})
+	if err != nil {
+		return nil, err
+	}
+	return runner.Jobs[invocation.Label], nil
+}
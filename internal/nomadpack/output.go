@@ -0,0 +1,70 @@
+package nomadpack
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+)
+
+// lineWriter captures everything written to it and, unless quiet, forwards every complete
+// line to out prefixed with the label of the invocation. stdout and stderr writers of the same
+// command share the mutex so their lines are not interleaved.
+type lineWriter struct {
+	captured bytes.Buffer
+	pending  bytes.Buffer
+	prefix   string
+	quiet    bool
+	out      func(line string)
+	mu       *sync.Mutex
+}
+
+func newLineWriter(label string, quiet bool, out func(line string), mu *sync.Mutex) *lineWriter {
+	prefix := ""
+	if label != "" {
+		prefix = "[" + label + "] "
+	}
+	return &lineWriter{prefix: prefix, quiet: quiet, out: out, mu: mu}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.captured.Write(p)
+	if w.quiet {
+		return len(p), nil
+	}
+
+	w.pending.Write(p)
+	for {
+		line, err := w.pending.ReadString('\n')
+		if err == io.EOF {
+			// Keep the incomplete line until the rest of it arrives.
+			w.pending.WriteString(line)
+			break
+		}
+		w.out(w.prefix + strings.TrimRight(line, "\r\n"))
+	}
+
+	return len(p), nil
+}
+
+// Flush forwards the last line when the output did not end with a new line.
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.pending.Len() > 0 {
+		w.out(w.prefix + w.pending.String())
+		w.pending.Reset()
+	}
+}
+
+// String returns everything that was written.
+func (w *lineWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.captured.String()
+}
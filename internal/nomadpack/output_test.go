@@ -0,0 +1,43 @@
+package nomadpack
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestLineWriter(t *testing.T) {
+	lines := []string{}
+	writer := newLineWriter("staging/app", false, func(line string) { lines = append(lines, line) }, &sync.Mutex{})
+
+	writer.Write([]byte("first line\nsecond "))
+	writer.Write([]byte("line\r\nlast"))
+	writer.Flush()
+
+	expected := []string{"[staging/app] first line", "[staging/app] second line", "[staging/app] last"}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, lines)
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], lines[i])
+		}
+	}
+	if writer.String() != "first line\nsecond line\r\nlast" {
+		t.Errorf("unexpected captured output %q", writer.String())
+	}
+}
+
+func TestLineWriterQuiet(t *testing.T) {
+	lines := []string{}
+	writer := newLineWriter("staging/app", true, func(line string) { lines = append(lines, line) }, &sync.Mutex{})
+
+	writer.Write([]byte("first line\nlast"))
+	writer.Flush()
+
+	if len(lines) != 0 {
+		t.Errorf("expected no output in quiet mode, got %v", lines)
+	}
+	if writer.String() != "first line\nlast" {
+		t.Errorf("unexpected captured output %q", writer.String())
+	}
+}
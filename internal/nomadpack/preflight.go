@@ -0,0 +1,104 @@
+package nomadpack
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	nomad "github.com/hashicorp/nomad/api"
+)
+
+// preflightJobID is the ID of the (never registered) job planned to check the token can submit jobs.
+const preflightJobID = "nomad-packfile-preflight"
+
+// PreflightReport is the outcome of the pre-flight checks against a Nomad cluster. Problems prevent
+// using the cluster, warnings do not.
+type PreflightReport struct {
+	ServerVersion string
+	Problems      []error
+	Warnings      []error
+}
+
+// Preflight checks that the cluster of connection is reachable and that the token can be used to
+// deploy: the checks of CheckConnection pass and jobs can be submitted.
+func Preflight(connection Connection) PreflightReport {
+	report, client := checkConnection(connection)
+	if len(report.Problems) > 0 {
+		return report
+	}
+
+	// Permissions are checked before the job is validated, so planning an empty job is rejected
+	// with "Permission denied" only if the token lacks the submit-job capability.
+	_, _, err := client.Jobs().Plan(nomad.NewBatchJob(preflightJobID, preflightJobID, connection.Region, 50), false, nil)
+	if err != nil && isPermissionDenied(err) {
+		report.Problems = append(report.Problems, fmt.Errorf("token is not allowed to submit jobs in namespace %s: %v", namespaceOrDefault(connection.Namespace), err))
+	}
+	return report
+}
+
+// CheckConnection checks that the cluster of connection is reachable, that the token is valid and
+// that the namespace exists. The server version is only fetched when the token has the agent:read
+// capability, it is a warning otherwise.
+func CheckConnection(connection Connection) PreflightReport {
+	report, _ := checkConnection(connection)
+	return report
+}
+
+func checkConnection(connection Connection) (PreflightReport, *nomad.Client) {
+	report := PreflightReport{}
+	problem := func(format string, a ...any) (PreflightReport, *nomad.Client) {
+		report.Problems = append(report.Problems, fmt.Errorf(format, a...))
+		return report, nil
+	}
+
+	if connection.Address == "" {
+		return problem("Nomad address is required")
+	}
+	client, err := nomad.NewClient(connection.APIConfig())
+	if err != nil {
+		return problem("could not create Nomad client: %v", err)
+	}
+
+	_, err = client.Status().Peers()
+	if err != nil {
+		return problem("could not connect to Nomad at %s: %v", connection.Address, err)
+	}
+
+	// Deploy tokens do not usually have agent:read, the version is only informative.
+	agent, err := client.Agent().Self()
+	if err != nil {
+		report.Warnings = append(report.Warnings, fmt.Errorf("could not fetch the Nomad server version: %v", err))
+	} else {
+		report.ServerVersion = agent.Member.Tags["build"]
+	}
+
+	_, _, err = client.ACLTokens().Self(nil)
+	if err != nil && !isACLDisabled(err) {
+		return problem("invalid Nomad token: %v", err)
+	}
+
+	if connection.Namespace != "" {
+		_, _, err = client.Namespaces().Info(connection.Namespace, nil)
+		if err != nil {
+			return problem("namespace %s is not available: %v", connection.Namespace, err)
+		}
+	}
+	return report, client
+}
+
+// isACLDisabled returns whether err is the answer of a cluster without ACLs to a token lookup, a
+// 400 that can only be told apart from other ones by its message.
+func isACLDisabled(err error) bool {
+	return hasStatusCode(err, http.StatusBadRequest) && strings.Contains(err.Error(), "ACL support disabled")
+}
+
+func isPermissionDenied(err error) bool {
+	return hasStatusCode(err, http.StatusForbidden)
+}
+
+func namespaceOrDefault(namespace string) string {
+	if namespace == "" {
+		return "default"
+	}
+	return namespace
+}
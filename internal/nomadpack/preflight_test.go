@@ -0,0 +1,76 @@
+package nomadpack
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/magec/nomad-packfile/test/nomadtest"
+)
+
+func TestPreflight(t *testing.T) {
+	server := nomadtest.NewServer(t)
+	server.Token = "secret"
+
+	report := Preflight(Connection{Address: server.URL, Token: "secret"})
+	if len(report.Problems) != 0 || len(report.Warnings) != 0 {
+		t.Fatalf("expected no problems, got %v %v", report.Problems, report.Warnings)
+	}
+	if report.ServerVersion != nomadtest.DefaultVersion {
+		t.Errorf("expected server version %s, got %q", nomadtest.DefaultVersion, report.ServerVersion)
+	}
+}
+
+func TestPreflightWithoutAgentRead(t *testing.T) {
+	server := nomadtest.NewServer(t)
+	server.Denied = []string{"/v1/agent/"}
+
+	report := Preflight(Connection{Address: server.URL})
+	if len(report.Problems) != 0 {
+		t.Errorf("expected a token without agent:read to be allowed, got %v", report.Problems)
+	}
+	if len(report.Warnings) != 1 || !strings.Contains(report.Warnings[0].Error(), "could not fetch the Nomad server version") {
+		t.Errorf("expected a warning about the server version, got %v", report.Warnings)
+	}
+	if report.ServerVersion != "" {
+		t.Errorf("expected an unknown server version, got %q", report.ServerVersion)
+	}
+}
+
+func TestPreflightProblems(t *testing.T) {
+	server := nomadtest.NewServer(t)
+	server.Token = "secret"
+
+	cases := map[string]struct {
+		connection Connection
+		denied     []string
+		expected   string
+	}{
+		"no address":        {Connection{}, nil, "Nomad address is required"},
+		"unreachable":       {Connection{Address: "http://127.0.0.1:1"}, nil, "could not connect to Nomad"},
+		"invalid token":     {Connection{Address: server.URL, Token: "wrong"}, nil, "invalid Nomad token"},
+		"missing namespace": {Connection{Address: server.URL, Token: "secret", Namespace: "prod"}, nil, "namespace prod is not available"},
+		"cannot submit":     {Connection{Address: server.URL, Token: "secret"}, []string{"/v1/job/"}, "token is not allowed to submit jobs in namespace default"},
+	}
+	for name, c := range cases {
+		server.Denied = c.denied
+		report := Preflight(c.connection)
+		if len(report.Problems) != 1 || !strings.Contains(report.Problems[0].Error(), c.expected) {
+			t.Errorf("%s: expected a problem containing %q, got %v", name, c.expected, report.Problems)
+		}
+	}
+}
+
+func TestCheckConnectionDoesNotNeedSubmitJob(t *testing.T) {
+	server := nomadtest.NewServer(t)
+	server.Denied = []string{"/v1/job/"}
+
+	report := CheckConnection(Connection{Address: server.URL})
+	if len(report.Problems) != 0 {
+		t.Errorf("expected a read only token to be enough, got %v", report.Problems)
+	}
+	for _, request := range server.Requests() {
+		if strings.HasSuffix(request, "/plan") {
+			t.Errorf("expected no job to be planned, got %s", request)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package nomadpack
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// ParseRegistryList returns the registries listed in the output of nomad-pack registry list, a
+// table whose columns are separated by | and found by their header: the registry name (REGISTRY
+// NAME, or REGISTRY in the tech preview which lists every pack), REF and the url. Registries
+// without ref are at the default one, nil. When there is no name or REF column the output is not
+// understood and no registry is returned, so they are all added again.
+func ParseRegistryList(output string) []Registry {
+	registries := []Registry{}
+	seen := map[string]bool{}
+	name, ref, url := -1, -1, -1
+	header := true
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.Contains(line, "|") {
+			continue
+		}
+		columns := strings.Split(line, "|")
+		for i := range columns {
+			columns[i] = strings.TrimSpace(columns[i])
+		}
+
+		if header {
+			header = false
+			for i, column := range columns {
+				switch strings.ToUpper(column) {
+				case "REGISTRY NAME", "REGISTRY":
+					name = i
+				case "REF":
+					ref = i
+				case "REGISTRY URL", "URL", "SOURCE":
+					url = i
+				}
+			}
+			if name == -1 || ref == -1 {
+				return []Registry{}
+			}
+			continue
+		}
+
+		if name >= len(columns) || columns[name] == "" || strings.Trim(columns[name], "-+") == "" {
+			continue
+		}
+		registry := Registry{Name: columns[name]}
+		registryRef := ""
+		if ref < len(columns) {
+			registryRef = columns[ref]
+		}
+		if registryRef != "" {
+			registry.Ref = &registryRef
+		}
+		if url != -1 && url < len(columns) {
+			registry.URL = columns[url]
+		}
+		// The tech preview lists a row per pack.
+		key := registry.Name + "@" + registryRef
+		if !seen[key] {
+			seen[key] = true
+			registries = append(registries, registry)
+		}
+	}
+	return registries
+}
+
+// Registries runs nomad-pack registry list without showing its output.
+func (nomadPack *NomadPack) Registries() ([]Registry, error) {
+	cmd := exec.Command(nomadPack.binaryPath, "registry", "list")
+
+	quiet := *nomadPack
+	quiet.quiet = true
+	invocation := Invocation{EnvPassthrough: nomadPack.envPassthrough, Label: "registries"}
+	result, err := quiet.runCommand(cmd, invocation)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRegistryList(result.Stdout), nil
+}
@@ -0,0 +1,59 @@
+package nomadpack
+
+import (
+	"testing"
+)
+
+func TestParseRegistryList(t *testing.T) {
+	// The table of nomad-pack 0.1, one row per registry.
+	output := `  REGISTRY NAME |  REF   | LOCAL REF |                    REGISTRY URL
+----------------+--------+-----------+-----------------------------------------------------
+  default       | latest | 0f6a2b1   | github.com/hashicorp/nomad-pack-community-registry
+  myorg         | v1.0.0 | 9c1e3d4   | github.com/myorg/packs
+`
+	registries := ParseRegistryList(output)
+	if len(registries) != 2 {
+		t.Fatalf("expected 2 registries, got %+v", registries)
+	}
+	myorg := registries[1]
+	if myorg.Name != "myorg" || *myorg.Ref != "v1.0.0" || myorg.URL != "github.com/myorg/packs" {
+		t.Errorf("unexpected registry %+v", myorg)
+	}
+
+	if registries := ParseRegistryList("No registries present in the cache.\n"); len(registries) != 0 {
+		t.Errorf("expected no registries, got %+v", registries)
+	}
+}
+
+func TestParseRegistryListOfPacks(t *testing.T) {
+	// The table of the tech preview, one row per pack: the name column is the one of the registry.
+	output := `      PACK NAME      |  REF   | METADATA VERSION |  REGISTRY  |                    REGISTRY URL
+---------------------+--------+------------------+------------+-----------------------------------------------------
+  hello_world        | latest | 0.0.1            | default    | github.com/hashicorp/nomad-pack-community-registry
+  traefik            | latest | 0.0.1            | default    | github.com/hashicorp/nomad-pack-community-registry
+  app                | v1.0.0 | 0.0.1            | myorg      | github.com/myorg/packs
+`
+	registries := ParseRegistryList(output)
+	if len(registries) != 2 || registries[0].Name != "default" || registries[1].Name != "myorg" || *registries[1].Ref != "v1.0.0" {
+		t.Errorf("expected the registries of the packs, got %+v", registries)
+	}
+}
+
+func TestParseRegistryListWithoutRef(t *testing.T) {
+	// Without a REF column the refs the registries are cached at are unknown.
+	output := `  REGISTRY NAME |                    REGISTRY URL
+----------------+-----------------------------------------------------
+  myorg         | github.com/myorg/packs
+`
+	if registries := ParseRegistryList(output); len(registries) != 0 {
+		t.Errorf("expected the registries not to be considered cached, got %+v", registries)
+	}
+
+	output = `  PACK NAME |  REF
+------------+--------
+  app       | v1.0.0
+`
+	if registries := ParseRegistryList(output); len(registries) != 0 {
+		t.Errorf("expected pack names not to be taken as registries, got %+v", registries)
+	}
+}
@@ -0,0 +1,47 @@
+package nomadpack
+
+import (
+	"fmt"
+
+	nomad "github.com/hashicorp/nomad/api"
+)
+
+// RevertJob reverts the job to the latest stable version prior to fromVersion and returns it.
+// The revert is only applied if the job is still at fromVersion.
+func (cluster *Cluster) RevertJob(jobID JobID, fromVersion uint64) (uint64, error) {
+	client, err := nomad.NewClient(cluster.connection.APIConfig())
+	if err != nil {
+		return 0, err
+	}
+
+	versions, _, _, err := client.Jobs().Versions(jobID.ID, false, jobID.queryOptions())
+	if err != nil {
+		return 0, fmt.Errorf("could not fetch the versions of job %s: %w", jobID, err)
+	}
+
+	stable, found := previousStableVersion(versions, fromVersion)
+	if !found {
+		return 0, fmt.Errorf("job %s has no stable version prior to %d", jobID, fromVersion)
+	}
+
+	_, _, err = client.Jobs().Revert(jobID.ID, stable, &fromVersion, jobID.writeOptions(), "", "")
+	if err != nil {
+		return 0, fmt.Errorf("could not revert job %s to version %d: %w", jobID, stable, err)
+	}
+	cluster.printLine(fmt.Sprintf("job %s reverted from version %d to version %d", jobID, fromVersion, stable))
+
+	return stable, nil
+}
+
+// previousStableVersion returns the highest stable version lower than version.
+func previousStableVersion(versions []*nomad.Job, version uint64) (stable uint64, found bool) {
+	for _, job := range versions {
+		if job.Version == nil || job.Stable == nil || !*job.Stable || *job.Version >= version {
+			continue
+		}
+		if !found || *job.Version > stable {
+			stable, found = *job.Version, true
+		}
+	}
+	return stable, found
+}
@@ -0,0 +1,24 @@
+package nomadpack
+
+import (
+	"testing"
+
+	nomad "github.com/hashicorp/nomad/api"
+)
+
+func TestPreviousStableVersion(t *testing.T) {
+	job := func(version uint64, stable bool) *nomad.Job {
+		return &nomad.Job{Version: &version, Stable: &stable}
+	}
+	versions := []*nomad.Job{job(4, false), job(3, false), job(2, true), job(1, true), job(0, true)}
+
+	stable, found := previousStableVersion(versions, 4)
+	if !found || stable != 2 {
+		t.Errorf("expected version 2, got %d (found: %v)", stable, found)
+	}
+
+	_, found = previousStableVersion(versions, 0)
+	if found {
+		t.Errorf("expected no stable version before the first one")
+	}
+}
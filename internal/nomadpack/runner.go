@@ -0,0 +1,86 @@
+package nomadpack
+
+import (
+	"go.uber.org/zap"
+)
+
+// Runner runs the nomad-pack operations nomad-packfile needs.
+type Runner interface {
+	// AddRegistry adds (or updates) a registry to the nomad-pack cache.
+	AddRegistry(registry Registry) error
+	// Registries returns the registries in the nomad-pack cache, with the ref they were added at.
+	Registries() ([]Registry, error)
+	// Plan plans the pack, the result has Changes set when the plan would modify the cluster.
+	Plan(invocation Invocation) (*Result, error)
+	// Run deploys the pack.
+	Run(invocation Invocation) (*Result, error)
+	// Render renders the pack templates, to invocation.ToDir when set.
+	Render(invocation Invocation) (*Result, error)
+	// Destroy stops and removes the jobs of the pack.
+	Destroy(invocation Invocation) (*Result, error)
+	// JobIDs returns the IDs of the jobs the pack defines, without showing any output.
+	JobIDs(invocation Invocation) ([]JobID, error)
+}
+
+// Registry is a nomad-pack registry.
+type Registry struct {
+	Name string
+	URL  string
+	// Ref is the git ref of the registry to add, the default branch when nil.
+	Ref *string
+	// Target is the only pack of the registry to add, all of them when nil.
+	Target *string
+	// EnvPassthrough selects the variables of the current environment passed to nomad-pack, on top
+	// of the ones of the runner.
+	EnvPassthrough EnvPassthrough
+	// Env are extra variables for nomad-pack, e.g. to reach a private git registry.
+	Env map[string]string
+}
+
+// Pack identifies a pack: a local path or a pack of a registry.
+type Pack struct {
+	Name     string
+	Registry string
+	Ref      string
+}
+
+// Invocation is a nomad-pack operation on the pack of a release.
+type Invocation struct {
+	// WorkDir is the directory nomad-pack is run in, relative paths are resolved against it.
+	WorkDir  string
+	Pack     Pack
+	VarFiles []string
+	Vars     map[string]string
+	// Connection holds the settings used to reach the Nomad cluster.
+	Connection Connection
+	// EnvPassthrough selects the variables of the current environment passed to nomad-pack.
+	EnvPassthrough EnvPassthrough
+	// Env are extra variables for nomad-pack, they take precedence over the passed through ones.
+	Env map[string]string
+	// Label prefixes every line of output, e.g. environment/release.
+	Label string
+	// ToDir makes Render write the rendered templates to this directory instead of printing them.
+	ToDir string
+}
+
+// RunnerOptions configures the Runner returned by NewRunner.
+type RunnerOptions struct {
+	// BinaryPath is the nomad-pack binary to run.
+	BinaryPath string
+	// VersionConstraint, when set, is checked against the version of the nomad-pack binary.
+	VersionConstraint string
+	// EnvPassthrough selects the variables of the current environment passed to nomad-pack when it
+	// is not run for a release.
+	EnvPassthrough EnvPassthrough
+	// Quiet makes nomad-pack output to be shown only when the operation fails.
+	Quiet bool
+}
+
+// NewRunner returns the Runner running the nomad-pack binary.
+func NewRunner(options RunnerOptions, logger *zap.Logger) (Runner, error) {
+	nomadPack, err := New(options.BinaryPath, options.VersionConstraint, logger)
+	if err != nil {
+		return nil, err
+	}
+	return nomadPack.EnvPassthrough(options.EnvPassthrough).Quiet(options.Quiet), nil
+}
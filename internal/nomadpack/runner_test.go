@@ -0,0 +1,23 @@
+package nomadpack
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPackParams(t *testing.T) {
+	cases := []struct {
+		pack     Pack
+		expected []string
+	}{
+		{Pack{Name: "./packs/app"}, []string{"./packs/app"}},
+		{Pack{Name: "app", Registry: "myorg"}, []string{"--registry", "myorg", "app"}},
+		{Pack{Name: "app", Registry: "myorg", Ref: "v1.0.0"}, []string{"--registry", "myorg", "--ref", "v1.0.0", "app"}},
+	}
+	for _, c := range cases {
+		params := packParams(c.pack)
+		if !slices.Equal(params, c.expected) {
+			t.Errorf("packParams(%+v) = %v, expected %v", c.pack, params, c.expected)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package nomadpack
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+)
+
+// JobStatusMissing is the status of the jobs defined by a pack that are not registered in Nomad.
+const JobStatusMissing = "missing"
+
+// JobStatus is the live state of a job in Nomad.
+type JobStatus struct {
+	JobID            string    `json:"job_id"`
+	Namespace        string    `json:"namespace,omitempty"`
+	Version          uint64    `json:"version"`
+	Status           string    `json:"status"`
+	Running          int       `json:"running"`
+	Desired          int       `json:"desired"`
+	DeploymentStatus string    `json:"deployment_status,omitempty"`
+	SubmitTime       time.Time `json:"submit_time,omitempty"`
+}
+
+// Job returns the ID of the job.
+func (status JobStatus) Job() JobID {
+	return JobID{Namespace: status.Namespace, ID: status.JobID}
+}
+
+// JobStatuses fetches the live state of the jobs from Nomad.
+func (cluster *Cluster) JobStatuses(jobIDs []JobID) ([]JobStatus, error) {
+	client, err := nomad.NewClient(cluster.connection.APIConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []JobStatus{}
+	for _, jobID := range jobIDs {
+		status, err := jobStatus(client, jobID)
+		if err != nil {
+			return statuses, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func jobStatus(client *nomad.Client, jobID JobID) (JobStatus, error) {
+	status := JobStatus{JobID: jobID.ID, Namespace: jobID.Namespace}
+	options := jobID.queryOptions()
+
+	job, _, err := client.Jobs().Info(jobID.ID, options)
+	if err != nil {
+		if isNotFound(err) {
+			status.Status = JobStatusMissing
+			return status, nil
+		}
+		return status, fmt.Errorf("could not fetch job %s: %w", jobID, err)
+	}
+	if job.Version != nil {
+		status.Version = *job.Version
+	}
+	if job.Status != nil {
+		status.Status = *job.Status
+	}
+	if job.SubmitTime != nil {
+		status.SubmitTime = time.Unix(0, *job.SubmitTime)
+	}
+	for _, group := range job.TaskGroups {
+		if group.Count != nil {
+			status.Desired += *group.Count
+		}
+	}
+
+	summary, _, err := client.Jobs().Summary(jobID.ID, options)
+	if err != nil {
+		return status, fmt.Errorf("could not fetch the summary of job %s: %w", jobID, err)
+	}
+	for _, group := range summary.Summary {
+		status.Running += group.Running
+	}
+
+	deployment, _, err := client.Jobs().LatestDeployment(jobID.ID, options)
+	if err != nil {
+		return status, fmt.Errorf("could not fetch the deployment of job %s: %w", jobID, err)
+	}
+	if deployment != nil {
+		status.DeploymentStatus = deployment.Status
+	}
+
+	return status, nil
+}
+
+func isNotFound(err error) bool {
+	return hasStatusCode(err, http.StatusNotFound)
+}
+
+// hasStatusCode returns whether err is the response of the Nomad API with the given status code.
+func hasStatusCode(err error, code int) bool {
+	var response nomad.UnexpectedResponseError
+	return errors.As(err, &response) && response.StatusCode() == code
+}
@@ -0,0 +1,86 @@
+package nomadpack
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/magec/nomad-packfile/test/nomadtest"
+)
+
+func TestJobStatuses(t *testing.T) {
+	server := nomadtest.NewServer(t)
+	server.Namespaces = []string{"default", "web"}
+	connection := Connection{Address: server.URL}
+	register(t, connection, nomad.NewServiceJob("app", "app", "", 50), 3)
+	batch, namespace := nomad.NewBatchJob("batch", "batch", "", 50), "web"
+	batch.Namespace = &namespace
+	register(t, connection, batch, 1)
+
+	statuses, err := NewCluster(connection, "").JobStatuses([]JobID{{ID: "app"}, {Namespace: "web", ID: "batch"}, {ID: "gone"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 3 {
+		t.Fatalf("expected a status per job, got %+v", statuses)
+	}
+
+	app := statuses[0]
+	if app.JobID != "app" || app.Status != "running" || app.Running != 3 || app.Desired != 3 || app.DeploymentStatus != DeploymentStatusSuccessful || app.SubmitTime.IsZero() {
+		t.Errorf("unexpected status of app %+v", app)
+	}
+	if statuses[1].Job() != (JobID{Namespace: "web", ID: "batch"}) || statuses[1].Running != 1 || statuses[1].DeploymentStatus != "" {
+		t.Errorf("expected the status of web/batch without deployment, got %+v", statuses[1])
+	}
+	if statuses[2].JobID != "gone" || statuses[2].Status != JobStatusMissing {
+		t.Errorf("expected gone to be missing, got %+v", statuses[2])
+	}
+}
+
+func TestJobStatusesErrors(t *testing.T) {
+	server := nomadtest.NewServer(t)
+	connection := Connection{Address: server.URL}
+	register(t, connection, nomad.NewServiceJob("app", "app", "", 50), 1)
+	register(t, connection, nomad.NewServiceJob("worker", "worker", "", 50), 1)
+	server.Denied = []string{"/v1/job/worker/summary"}
+
+	statuses, err := NewCluster(connection, "").JobStatuses([]JobID{{ID: "app"}, {ID: "worker"}, {ID: "api"}})
+	if err == nil || !strings.Contains(err.Error(), "could not fetch the summary of job worker") {
+		t.Errorf("expected the error of the summary, got %v", err)
+	}
+	if len(statuses) != 1 || statuses[0].JobID != "app" {
+		t.Errorf("expected the statuses fetched before the error, got %+v", statuses)
+	}
+
+	server.Denied = []string{"/v1/job/"}
+	_, err = NewCluster(connection, "").JobStatuses([]JobID{{ID: "app"}})
+	if err == nil || !strings.Contains(err.Error(), "could not fetch job app") {
+		t.Errorf("expected an error other than not found to fail, got %v", err)
+	}
+
+	// Only a 404 means the job is missing, whatever the message of other errors says.
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "namespace not found", http.StatusBadRequest)
+	}))
+	defer failing.Close()
+	_, err = NewCluster(Connection{Address: failing.URL}, "").JobStatuses([]JobID{{Namespace: "gone", ID: "app"}})
+	if err == nil || !strings.Contains(err.Error(), "could not fetch job gone/app") {
+		t.Errorf("expected a 400 to fail instead of the job being missing, got %v", err)
+	}
+}
+
+// register registers job with count allocations in its only group.
+func register(t *testing.T, connection Connection, job *nomad.Job, count int) {
+	t.Helper()
+	client, err := nomad.NewClient(connection.APIConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.AddTaskGroup(nomad.NewTaskGroup("app", count))
+	_, _, err = client.Jobs().Register(job, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
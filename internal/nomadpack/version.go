@@ -0,0 +1,157 @@
+package nomadpack
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version is a nomad-pack version, e.g. 0.1.2 or 0.2.0-dev.
+type Version struct {
+	Major, Minor, Patch int
+	// Prerelease is the part after the dash, e.g. dev or techpreview2.
+	Prerelease string
+}
+
+var versionPattern = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?$`)
+
+// versionOutputPattern finds the version in the output of nomad-pack version, e.g. "Nomad Pack v0.1.2 (3c8be3f)".
+var versionOutputPattern = regexp.MustCompile(`(?i)nomad pack\s+(v?\d+\.\d+\.\d+(?:-[0-9A-Za-z.-]+)?)`)
+
+// ParseVersion parses a version like 0.1.2, v0.1 or 0.2.0-dev. Missing minor and patch numbers are 0.
+func ParseVersion(version string) (Version, error) {
+	match := versionPattern.FindStringSubmatch(strings.TrimSpace(version))
+	if match == nil {
+		return Version{}, fmt.Errorf("invalid version %q", version)
+	}
+	numbers := [3]int{}
+	for i, number := range match[1:4] {
+		if number != "" {
+			numbers[i], _ = strconv.Atoi(number)
+		}
+	}
+	return Version{Major: numbers[0], Minor: numbers[1], Patch: numbers[2], Prerelease: match[4]}, nil
+}
+
+// ParseVersionOutput returns the version reported by nomad-pack version.
+func ParseVersionOutput(output string) (Version, error) {
+	match := versionOutputPattern.FindStringSubmatch(output)
+	if match == nil {
+		return Version{}, fmt.Errorf("could not find the version in %q", strings.TrimSpace(output))
+	}
+	return ParseVersion(match[1])
+}
+
+func (version Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", version.Major, version.Minor, version.Patch)
+	if version.Prerelease != "" {
+		s += "-" + version.Prerelease
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 when version is lower, equal or greater than other. A prerelease is
+// lower than the release with the same numbers.
+func (version Version) Compare(other Version) int {
+	if c := cmp.Compare(version.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(version.Minor, other.Minor); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(version.Patch, other.Patch); c != 0 {
+		return c
+	}
+	switch {
+	case version.Prerelease == other.Prerelease:
+		return 0
+	case version.Prerelease == "":
+		return 1
+	case other.Prerelease == "":
+		return -1
+	}
+	return cmp.Compare(version.Prerelease, other.Prerelease)
+}
+
+// Constraint is a set of version requirements that must all be met, e.g. ">= 0.1.0, < 0.2.0".
+// The supported operators are =, !=, >, >=, <, <= and ~> (pessimistic: ~> 0.1.2 means >= 0.1.2
+// and < 0.2.0, ~> 0.1 means >= 0.1.0 and < 1.0.0). A version without operator means =.
+type Constraint struct {
+	raw          string
+	requirements []requirement
+}
+
+type requirement struct {
+	operator string
+	version  Version
+	// parts is the number of version parts written, used by ~>.
+	parts int
+}
+
+var requirementPattern = regexp.MustCompile(`^(=|!=|>=|<=|>|<|~>)?\s*(\S+)$`)
+
+// ParseConstraint parses a constraint like ">= 0.1.0, < 0.2.0" or "~> 0.1".
+func ParseConstraint(constraint string) (Constraint, error) {
+	parsed := Constraint{raw: constraint}
+	for _, part := range strings.Split(constraint, ",") {
+		match := requirementPattern.FindStringSubmatch(strings.TrimSpace(part))
+		if match == nil {
+			return Constraint{}, fmt.Errorf("invalid version constraint %q", constraint)
+		}
+		version, err := ParseVersion(match[2])
+		if err != nil {
+			return Constraint{}, fmt.Errorf("invalid version constraint %q: %w", constraint, err)
+		}
+		operator := match[1]
+		if operator == "" {
+			operator = "="
+		}
+		parts := strings.Count(strings.SplitN(strings.TrimPrefix(match[2], "v"), "-", 2)[0], ".") + 1
+		parsed.requirements = append(parsed.requirements, requirement{operator: operator, version: version, parts: parts})
+	}
+	return parsed, nil
+}
+
+// Check returns whether version meets every requirement of the constraint.
+func (constraint Constraint) Check(version Version) bool {
+	for _, requirement := range constraint.requirements {
+		if !requirement.check(version) {
+			return false
+		}
+	}
+	return true
+}
+
+func (constraint Constraint) String() string {
+	return constraint.raw
+}
+
+func (requirement requirement) check(version Version) bool {
+	c := version.Compare(requirement.version)
+	switch requirement.operator {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case "~>":
+		if c < 0 {
+			return false
+		}
+		// The last written part may increase, the previous ones must match.
+		if requirement.parts <= 2 {
+			return version.Major == requirement.version.Major
+		}
+		return version.Major == requirement.version.Major && version.Minor == requirement.version.Minor
+	}
+	return false
+}
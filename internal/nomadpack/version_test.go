@@ -0,0 +1,106 @@
+package nomadpack
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	cases := map[string]Version{
+		"0.1.2":                 {Minor: 1, Patch: 2},
+		"v0.1":                  {Minor: 1},
+		"1":                     {Major: 1},
+		"0.2.0-dev":             {Minor: 2, Prerelease: "dev"},
+		" v0.0.1-techpreview3 ": {Patch: 1, Prerelease: "techpreview3"},
+	}
+	for input, expected := range cases {
+		version, err := ParseVersion(input)
+		if err != nil {
+			t.Errorf("ParseVersion(%q): %v", input, err)
+			continue
+		}
+		if version != expected {
+			t.Errorf("ParseVersion(%q) = %+v, expected %+v", input, version, expected)
+		}
+	}
+
+	for _, input := range []string{"", "latest", "0.1.2.3", "v"} {
+		if _, err := ParseVersion(input); err == nil {
+			t.Errorf("ParseVersion(%q): expected an error", input)
+		}
+	}
+}
+
+func TestParseVersionOutput(t *testing.T) {
+	version, err := ParseVersionOutput("Nomad Pack v0.1.2 (3c8be3f)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version.String() != "0.1.2" {
+		t.Errorf("expected 0.1.2, got %s", version)
+	}
+
+	_, err = ParseVersionOutput("Usage: nomad-pack <command>")
+	if err == nil {
+		t.Error("expected an error when the output has no version")
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"0.1.2", "0.1.2", 0},
+		{"0.1.2", "0.1.10", -1},
+		{"1.0.0", "0.9.9", 1},
+		{"0.2.0-dev", "0.2.0", -1},
+		{"0.2.0", "0.2.0-dev", 1},
+		{"0.2.0-alpha", "0.2.0-beta", -1},
+	}
+	for _, c := range cases {
+		a, _ := ParseVersion(c.a)
+		b, _ := ParseVersion(c.b)
+		if compared := a.Compare(b); compared != c.expected {
+			t.Errorf("%s compared to %s = %d, expected %d", c.a, c.b, compared, c.expected)
+		}
+	}
+}
+
+func TestConstraintCheck(t *testing.T) {
+	cases := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{"0.1.2", "0.1.2", true},
+		{"= 0.1.2", "0.1.3", false},
+		{"!= 0.1.2", "0.1.3", true},
+		{">= 0.1.0, < 0.2.0", "0.1.9", true},
+		{">= 0.1.0, < 0.2.0", "0.2.0", false},
+		{">= 0.1.0", "0.1.0-dev", false},
+		{"> 0.1.0", "0.1.1", true},
+		{"<= 0.1.0", "0.1.0", true},
+		{"~> 0.1.2", "0.1.9", true},
+		{"~> 0.1.2", "0.2.0", false},
+		{"~> 0.1.2", "0.1.1", false},
+		{"~> 0.1", "0.9.0", true},
+		{"~> 0.1", "1.0.0", false},
+	}
+	for _, c := range cases {
+		constraint, err := ParseConstraint(c.constraint)
+		if err != nil {
+			t.Errorf("ParseConstraint(%q): %v", c.constraint, err)
+			continue
+		}
+		version, _ := ParseVersion(c.version)
+		if constraint.Check(version) != c.expected {
+			t.Errorf("%q checked against %s: expected %v", c.constraint, c.version, c.expected)
+		}
+	}
+}
+
+func TestParseConstraintInvalid(t *testing.T) {
+	for _, input := range []string{"", "=> 0.1.0", ">= 0.1.0,", ">= latest"} {
+		if _, err := ParseConstraint(input); err == nil {
+			t.Errorf("ParseConstraint(%q): expected an error", input)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package nomadpackfile
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	configpkg "github.com/magec/nomad-packfile/internal/config"
+	"github.com/magec/nomad-packfile/internal/nomadpack"
+)
+
+// connectionSettings returns the (templatable) connection settings of release by their name in the packfile.
+func connectionSettings(release *configpkg.ReleaseConfig) map[string]*string {
+	return map[string]*string{
+		"nomad-addr":            &release.NomadAddr,
+		"nomad-token":           &release.NomadToken,
+		"nomad-namespace":       &release.NomadNamespace,
+		"nomad-region":          &release.NomadRegion,
+		"nomad-cacert":          &release.NomadCACert,
+		"nomad-client-cert":     &release.NomadClientCert,
+		"nomad-client-key":      &release.NomadClientKey,
+		"nomad-tls-server-name": &release.NomadTLSServerName,
+		"nomad-skip-verify":     &release.NomadSkipVerify,
+	}
+}
+
+// inheritConnection overrides the connection settings of release with the ones set in the
+// environment and returns the names of the overridden ones.
+func inheritConnection(release *configpkg.ReleaseConfig, environment configpkg.ReleaseConfig) map[string]bool {
+	inherited := map[string]bool{}
+	environmentSettings := connectionSettings(&environment)
+	for name, value := range connectionSettings(release) {
+		if *environmentSettings[name] != "" {
+			*value = *environmentSettings[name]
+			inherited[name] = true
+		}
+	}
+	return inherited
+}
+
+// compileConnection interprets the templates in the connection settings of release, overridden by
+// the ones of environment. Relative certificate paths are resolved against the directory of the
+// file that set them.
+func compileConnection(release, environment configpkg.ReleaseConfig, workDir string, context templateContext) (nomadpack.Connection, error) {
+	inherited := inheritConnection(&release, environment)
+	certificatePath := func(name, path string) string {
+		if inherited[name] {
+			return resolvePath(environment.Dir(workDir), path)
+		}
+		return resolvePath(release.Dir(workDir), path)
+	}
+
+	for name, value := range connectionSettings(&release) {
+		compiled, err := execTemplate(*value, context)
+		if err != nil {
+			return nomadpack.Connection{}, fmt.Errorf("error interpreting template in %s: %s, err: %w", name, *value, err)
+		}
+		*value = compiled
+	}
+
+	skipVerify := false
+	if release.NomadSkipVerify != "" {
+		var err error
+		skipVerify, err = strconv.ParseBool(release.NomadSkipVerify)
+		if err != nil {
+			return nomadpack.Connection{}, fmt.Errorf("invalid value for nomad-skip-verify: %s", release.NomadSkipVerify)
+		}
+	}
+
+	return nomadpack.Connection{
+		Address:       release.NomadAddr,
+		Token:         release.NomadToken,
+		Namespace:     release.NomadNamespace,
+		Region:        release.NomadRegion,
+		CACert:        certificatePath("nomad-cacert", release.NomadCACert),
+		ClientCert:    certificatePath("nomad-client-cert", release.NomadClientCert),
+		ClientKey:     certificatePath("nomad-client-key", release.NomadClientKey),
+		TLSServerName: release.NomadTLSServerName,
+		SkipVerify:    skipVerify,
+	}, nil
+}
+
+// resolvePath makes path absolute using dir as the base for relative ones.
+func resolvePath(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
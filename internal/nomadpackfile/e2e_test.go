@@ -0,0 +1,198 @@
+package nomadpackfile
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/magec/nomad-packfile/internal/history"
+	"github.com/magec/nomad-packfile/internal/nomadpack"
+	"github.com/magec/nomad-packfile/test"
+	"github.com/magec/nomad-packfile/test/nomadtest"
+)
+
+// These tests run the fake nomad-pack binary against the fake Nomad API server.
+
+const e2ePackfile = `
+environments:
+  staging:
+    nomad-addr: %s
+    nomad-token: %s
+releases:
+  - name: app
+    pack: ./packs/app
+    vars:
+      count: "%d"
+    wait: true
+    rollback-on-failure: true
+`
+
+func TestEndToEndPlanRunDestroy(t *testing.T) {
+	server := nomadtest.NewServer(t)
+	server.Token = "secret"
+	nomadPackFile := compileE2E(t, server, "secret", 2)
+
+	err := nomadPackFile.Preflight()
+	if err != nil {
+		t.Fatalf("preflight: %v", err)
+	}
+
+	results, err := nomadPackFile.Plan()
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if !results[0].Result.Changes {
+		t.Error("expected the plan of a new job to have changes")
+	}
+
+	results, err = nomadPackFile.Run()
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	deployments := results[0].Result.Deployments
+	if len(deployments) != 1 || deployments[0].JobID != "app" || deployments[0].Status != nomadpack.DeploymentStatusSuccessful {
+		t.Errorf("expected a successful deployment of app, got %+v", deployments)
+	}
+	job := server.Job("app")
+	if job == nil || *job.TaskGroups[0].Count != 2 {
+		t.Fatalf("expected job app with 2 allocations to be registered, got %+v", job)
+	}
+
+	records, err := nomadPackFile.releases[0].History()
+	if err != nil {
+		t.Fatalf("history: %v", err)
+	}
+	if len(records) != 1 || records[0].Status != history.StatusDeployed || records[0].Vars["count"] != "2" {
+		t.Errorf("expected the run to be recorded, got %+v", records)
+	}
+
+	results, err = nomadPackFile.Plan()
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if results[0].Result.Changes {
+		t.Error("expected no changes after running the release")
+	}
+
+	statuses, err := nomadPackFile.Status()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	jobs := statuses[0].Jobs
+	if len(jobs) != 1 || jobs[0].Running != 2 || jobs[0].DeploymentStatus != nomadpack.DeploymentStatusSuccessful {
+		t.Errorf("unexpected status %+v", jobs)
+	}
+
+	_, err = nomadPackFile.Destroy()
+	if err != nil {
+		t.Fatalf("destroy: %v", err)
+	}
+	if server.Job("app") != nil {
+		t.Error("expected job app to be purged")
+	}
+
+	if !slices.Contains(nomadtest.Calls(t), "destroy -var count=2 ./packs/app") {
+		t.Errorf("expected nomad-pack destroy to be called, got %v", nomadtest.Calls(t))
+	}
+}
+
+func TestEndToEndRollbackOnFailure(t *testing.T) {
+	server := nomadtest.NewServer(t)
+	_, err := compileE2E(t, server, "", 1).Run()
+	if err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+
+	server.SetDeploymentStatus("failed")
+	results, err := compileE2E(t, server, "", 3).Run()
+	if err == nil {
+		t.Fatal("expected the failed deployment to fail the run")
+	}
+	deployment := results[0].Result.Deployments[0]
+	if deployment.Status != nomadpack.DeploymentStatusFailed || deployment.RolledBackTo == nil || *deployment.RolledBackTo != 0 {
+		t.Errorf("expected the job to be rolled back to version 0, got %+v", deployment)
+	}
+	job := server.Job("app")
+	if *job.Version != 2 || *job.TaskGroups[0].Count != 1 {
+		t.Errorf("expected version 0 to be registered again as version 2, got version %d with count %d", *job.Version, *job.TaskGroups[0].Count)
+	}
+
+	store, err := history.New(nomadpack.Connection{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := store.List("app", "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records[len(records)-1].Status != history.StatusRolledBack {
+		t.Errorf("expected the run to be recorded as rolled back, got %s", records[len(records)-1].Status)
+	}
+}
+
+func TestEndToEndJobWithItsOwnIDAndNamespace(t *testing.T) {
+	server := nomadtest.NewServer(t)
+	server.Namespaces = []string{"default", "web"}
+	runner, err := nomadpack.New(nomadtest.BuildNomadPack(t), "", test.GetLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nomadPackFile, err := compile(t, fmt.Sprintf(`
+environments:
+  staging:
+    nomad-addr: %s
+releases:
+  - name: app
+    pack: ./packs/app
+    vars:
+      job_id: app-web
+      job_namespace: web
+    wait: true
+`, server.URL), runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := nomadPackFile.Run()
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	deployments := results[0].Result.Deployments
+	if len(deployments) != 1 || deployments[0].JobID != "app-web" || deployments[0].Namespace != "web" || deployments[0].Status != nomadpack.DeploymentStatusSuccessful {
+		t.Errorf("expected a successful deployment of web/app-web, got %+v", deployments)
+	}
+
+	statuses, err := nomadPackFile.Status()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	jobs := statuses[0].Jobs
+	if len(jobs) != 1 || jobs[0].Status == nomadpack.JobStatusMissing || jobs[0].Namespace != "web" {
+		t.Errorf("expected the status of web/app-web, got %+v", jobs)
+	}
+}
+
+func TestEndToEndPreflightInvalidToken(t *testing.T) {
+	server := nomadtest.NewServer(t)
+	server.Token = "secret"
+
+	err := compileE2E(t, server, "wrong", 1).Preflight()
+	if err == nil || !strings.Contains(err.Error(), "invalid Nomad token") {
+		t.Fatalf("expected an invalid token error, got %v", err)
+	}
+}
+
+// compileE2E compiles e2ePackfile for server using the fake nomad-pack.
+func compileE2E(t *testing.T, server *nomadtest.Server, token string, count int) *NomadPackFile {
+	t.Helper()
+	runner, err := nomadpack.New(nomadtest.BuildNomadPack(t), "", test.GetLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nomadPackFile, err := compile(t, fmt.Sprintf(e2ePackfile, server.URL, token, count), runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nomadPackFile
+}
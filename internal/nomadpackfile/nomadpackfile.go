@@ -2,26 +2,50 @@ package nomadpackfile
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"log"
+	"maps"
 	"os"
+	"path/filepath"
 	"slices"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/joho/godotenv"
 	configpkg "github.com/magec/nomad-packfile/internal/config"
+	"github.com/magec/nomad-packfile/internal/history"
+	"github.com/magec/nomad-packfile/internal/lock"
 	"github.com/magec/nomad-packfile/internal/nomadpack"
+	"github.com/magec/nomad-packfile/internal/redact"
+	"github.com/magec/nomad-packfile/internal/secrets"
 	"github.com/pterm/pterm"
 	"go.uber.org/zap"
 )
 
+// defaultWaitTimeout is used when waiting for deployments without an explicit timeout.
+const defaultWaitTimeout = 5 * time.Minute
+
 // This is a simple AST for the NomadPackFile
 type NomadPackFile struct {
 	config     configpkg.Config
 	registries map[string]RegistryNode
 	releases   []ReleaseNode
+	runner     nomadpack.Runner
 	logger     *zap.Logger
+	// addedRegistries holds the outcome of adding every registry ref in this run.
+	addedRegistries map[registryKey]error
+	// cachedRegistries are the urls of the registry refs nomad-pack had cached, nil until needed.
+	cachedRegistries map[registryKey]string
+	// packsOnly only compiles the packs of the releases, which is all Lock needs, so secrets files
+	// are not decrypted.
+	packsOnly bool
+}
+
+// registryKey identifies a registry at a ref, nomad-pack caches every ref of a registry separately.
+type registryKey struct {
+	name string
+	ref  string
 }
 
 type RegistryNode struct {
@@ -31,141 +55,677 @@ type RegistryNode struct {
 	/// URL of the registry
 	URL string
 
-	Ref           *string
-	Target        *string
+	Ref    *string
+	Target *string
+	// Commit is the commit Ref is pinned to in packfile.lock, if any.
+	Commit        string
 	NomadPackFile *NomadPackFile
 }
 
+// config returns the registry as configured, at the ref used by the release.
+func (registry RegistryNode) config() configpkg.RegistryConfig {
+	return configpkg.RegistryConfig{Name: registry.Name, URL: registry.URL, Ref: registry.Ref, Target: registry.Target}
+}
+
+// ref returns the ref nomad-pack has to use, the locked commit when there is one.
+func (registry RegistryNode) ref() *string {
+	if registry.Commit != "" {
+		return &registry.Commit
+	}
+	return registry.Ref
+}
+
 type Pack struct {
 	Name     string
 	Registry *RegistryNode
+	// Reference is the pack as written in the packfile, if it was compiled from it.
+	Reference PackReference
 }
 
-func (p Pack) NomadPackCmdOpts() (params []string) {
+// nomadPack returns the pack as understood by the nomad-pack runners.
+func (p Pack) nomadPack() nomadpack.Pack {
+	pack := nomadpack.Pack{Name: p.Name, Ref: p.Ref()}
 	if p.Registry != nil {
-		params = append(params, "--registry")
-		params = append(params, p.Registry.Name)
-		if p.Registry.Ref != nil {
-			params = append(params, "--ref")
-			params = append(params, *p.Registry.Ref)
-		}
+		pack.Registry = p.Registry.Name
 	}
-	params = append(params, p.Name)
+	return pack
+}
 
-	return params
+// String returns the pack reference as written in the packfile.
+func (p Pack) String() string {
+	if p.Reference.Kind != "" {
+		return p.Reference.String()
+	}
+	if p.Registry != nil {
+		return "registry://" + p.Registry.Name + "/" + p.Name
+	}
+	return p.Name
+}
+
+// Ref returns the git ref of the pack registry, if any. It is the commit locked in packfile.lock
+// when there is one.
+func (p Pack) Ref() string {
+	if p.Registry != nil && p.Registry.ref() != nil {
+		return *p.Registry.ref()
+	}
+	return ""
 }
 
 type ReleaseNode struct {
 	Name          string
+	Environment   string
 	Pack          Pack
 	VarFiles      []string
 	Vars          map[string]string
 	workDir       string
 	NomadPackFile *NomadPackFile
 	Environments  []string
-	NomadAddr     string
-	NomadToken    string
+	Connection    nomadpack.Connection
+	// EnvPassthrough selects the variables of the current environment passed to nomad-pack.
+	EnvPassthrough nomadpack.EnvPassthrough
+	// Env are extra variables for nomad-pack.
+	Env map[string]string
+	// Wait makes Run follow the deployments of the release jobs until they finish or WaitTimeout expires.
+	Wait        bool
+	WaitTimeout time.Duration
+	// RollbackOnFailure reverts the jobs whose deployment fails or times out to their previous stable version.
+	RollbackOnFailure bool
+	// varFilesDir is the directory VarFiles are relative to when it is not workDir.
+	varFilesDir string
+	// rollbackOf is the revision being run again by Rollback.
+	rollbackOf int
+	// secrets are the decrypted secrets files, the HCL ones are passed to nomad-pack as var files.
+	secrets []secrets.File
+}
+
+// varFilePaths returns the var files as they have to be passed to nomad-pack, which runs in workDir.
+func (release ReleaseNode) varFilePaths() []string {
+	if release.varFilesDir == "" {
+		return release.VarFiles
+	}
+	paths := []string{}
+	for _, varFile := range release.VarFiles {
+		paths = append(paths, filepath.Join(release.varFilesDir, varFile))
+	}
+	return paths
+}
+
+func (registry RegistryNode) key() registryKey {
+	if ref := registry.ref(); ref != nil && *ref != "" {
+		return registryKey{name: registry.Name, ref: *ref}
+	}
+	return registryKey{name: registry.Name, ref: lock.LatestRef}
+}
+
+// ReleaseResult is the outcome of a nomad-pack command for a release in a given environment.
+type ReleaseResult struct {
+	Environment string
+	Release     string
+	Result      nomadpack.Result
+	Err         error
+}
+
+// Failed returns whether nomad-pack failed for the release.
+func (result ReleaseResult) Failed() bool {
+	return result.Err != nil
 }
 
-func (registry RegistryNode) Plan() error {
-	nomadPack, err := registry.NomadPackFile.NomadPack()
+func newReleaseResult(release ReleaseNode, result *nomadpack.Result, err error) ReleaseResult {
+	releaseResult := ReleaseResult{Environment: release.Environment, Release: release.Name, Err: err}
+	if result != nil {
+		releaseResult.Result = *result
+	}
+	return releaseResult
+}
+
+func (release ReleaseNode) Plan() (*nomadpack.Result, error) {
+	invocation, remove, err := release.invocation()
+	if err != nil {
+		return nil, err
+	}
+	defer remove()
+	return release.NomadPackFile.runner.Plan(invocation)
+}
+
+// Run deploys the release. When release.Wait is set, it then waits for the deployments of the
+// release jobs and returns an error if any of them is not healthy, after reverting the job to its
+// previous stable version if release.RollbackOnFailure is set. Every run that reaches Nomad is
+// recorded in the release history.
+func (release ReleaseNode) Run() (*nomadpack.Result, error) {
+	runner := release.NomadPackFile.runner
+	invocation, remove, err := release.invocation()
 	if err != nil {
-		log.Fatalf("Error getting initializing nomad-pack: %s", err)
+		return nil, err
+	}
+	result, err := runner.Run(invocation)
+	remove()
+	if err != nil {
+		return result, err
+	}
+
+	if release.Wait {
+		err = release.waitForDeployments(runner, result)
 	}
+	release.recordRun(result, err)
 
-	return nomadPack.AddRegistry(registry.Name, registry.URL, registry.Ref, registry.Target)
+	return result, err
 }
 
-func (release ReleaseNode) Plan() error {
-	nomadPack, err := release.nomadPack()
+func (release ReleaseNode) waitForDeployments(runner nomadpack.Runner, result *nomadpack.Result) error {
+	jobIDs, err := release.jobIDs(runner)
 	if err != nil {
-		log.Fatalf("Error getting initializing nomad-pack: %s", err)
+		return fmt.Errorf("could not find the jobs of the release: %w", err)
 	}
 
-	return nomadPack.Plan(release.workDir, true, release.VarFiles, release.Vars, release.Pack.NomadPackCmdOpts())
+	cluster := release.cluster()
+	result.Deployments, err = cluster.WaitForDeployments(jobIDs, release.WaitTimeout)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for i, deployment := range result.Deployments {
+		if deployment.Healthy() {
+			continue
+		}
+		err := fmt.Errorf("deployment of job %s %s: %s", deployment.Job(), deployment.Status, deployment.Description)
+		if release.RollbackOnFailure {
+			version, rollbackErr := cluster.RevertJob(deployment.Job(), deployment.JobVersion)
+			if rollbackErr != nil {
+				err = fmt.Errorf("%w, rollback failed: %w", err, rollbackErr)
+			} else {
+				result.Deployments[i].RolledBackTo = &version
+				err = fmt.Errorf("%w, rolled back to version %d", err, version)
+			}
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
 }
 
-func (release ReleaseNode) Run() error {
-	nomadPack, err := release.nomadPack()
+// recordRun stores the run in the release history. As the release has already been deployed,
+// failing to record it is only reported as a warning.
+func (release ReleaseNode) recordRun(result *nomadpack.Result, runErr error) {
+	status := history.StatusDeployed
+	if runErr != nil {
+		status = history.StatusFailed
+		for _, deployment := range result.Deployments {
+			if deployment.RolledBackTo != nil {
+				status = history.StatusRolledBack
+			}
+		}
+	}
 
+	record, err := release.historyRecord(status)
+	if err == nil {
+		var store *history.Store
+		store, err = history.New(release.Connection)
+		if err == nil {
+			record, err = store.Add(record)
+		}
+	}
 	if err != nil {
-		log.Fatalf("Error getting initializing nomad-pack: %s", err)
+		pterm.Warning.Printf("Could not record the run of release %s in environment %s: %v\n", release.Name, release.Environment, err)
+		return
 	}
-	return nomadPack.Run(release.workDir, true, release.VarFiles, release.Vars, release.Pack.NomadPackCmdOpts())
+	pterm.DefaultBasicText.Printf("Recorded revision %d of release %s in environment %s.\n", record.Revision, release.Name, release.Environment)
 }
 
-func (release ReleaseNode) Render() error {
-	nomadPack, err := release.nomadPack()
+func (release ReleaseNode) historyRecord(status string) (history.Record, error) {
+	varFilesDir := release.varFilesDir
+	if varFilesDir == "" {
+		varFilesDir = release.workDir
+	}
+	varFiles, err := history.ReadVarFiles(varFilesDir, release.VarFiles)
 	if err != nil {
-		log.Fatalf("Error getting initializing nomad-pack: %s", err)
+		return history.Record{}, err
 	}
 
-	return nomadPack.Render(release.workDir, true, release.VarFiles, release.Vars, release.Pack.NomadPackCmdOpts())
+	record := history.Record{
+		Release:      release.Name,
+		Environment:  release.Environment,
+		User:         history.CurrentUser(),
+		GitSHA:       history.GitSHA(release.workDir),
+		Pack:         release.Pack.String(),
+		Ref:          release.Pack.Ref(),
+		VarFilesHash: history.HashVarFiles(varFiles),
+		VarsHash:     history.HashVars(release.Vars),
+		Status:       status,
+		Timestamp:    time.Now(),
+		RollbackOf:   release.rollbackOf,
+		PackName:     release.Pack.Name,
+		Vars:         map[string]string{},
+		VarFiles:     varFiles,
+	}
+	// The history is readable by every token with access to the variables of the cluster, the vars
+	// containing secrets (sensitive-vars, the sensitive function, secrets files) are left out and
+	// taken from the packfile on rollback.
+	for key, value := range release.Vars {
+		if redact.Contains(value) {
+			record.SensitiveVars = append(record.SensitiveVars, key)
+		} else {
+			record.Vars[key] = value
+		}
+	}
+	slices.Sort(record.SensitiveVars)
+	if release.Pack.Registry != nil {
+		record.Registry = release.Pack.Registry.Name
+		record.RegistryURL = release.Pack.Registry.URL
+	}
+	return record, nil
 }
 
-func (release ReleaseNode) nomadPack() (nomadPack *nomadpack.NomadPack, err error) {
-	nomadPack, err = release.NomadPackFile.NomadPack()
-	nomadPack = nomadPack.NomadAddr(release.NomadAddr).NomadToken(release.NomadToken)
-	return nomadPack, err
-}
+// Rollback runs the release again with the inputs recorded in the given revision of its history:
+// the same pack, registry ref, vars and var files (written to a temporary directory). The sensitive
+// vars, not recorded, are the ones of the packfile.
+func (release ReleaseNode) Rollback(revision int) (*nomadpack.Result, error) {
+	store, err := history.New(release.Connection)
+	if err != nil {
+		return nil, err
+	}
+	record, err := store.Get(release.Name, release.Environment, revision)
+	if err != nil {
+		return nil, err
+	}
+	if !record.HasInputs() {
+		return nil, fmt.Errorf("revision %d of release %s does not contain the inputs needed to run it again", revision, release.Name)
+	}
+
+	vars := map[string]string{}
+	for key, value := range record.Vars {
+		// Revisions recorded before a var was sensitive still have its value.
+		if redact.Contains(release.Vars[key]) {
+			redact.Add(value)
+		}
+		vars[key] = value
+	}
+	for _, key := range record.SensitiveVars {
+		value, ok := release.Vars[key]
+		if !ok {
+			return nil, fmt.Errorf("revision %d of release %s needs the sensitive var %s, which is not recorded and is not set in the packfile anymore", revision, release.Name, key)
+		}
+		vars[key] = value
+	}
 
-func New(config configpkg.Config, logger *zap.Logger) *NomadPackFile {
-	return &NomadPackFile{config: config, logger: logger, registries: make(map[string]RegistryNode)}
+	varFilesDir, err := os.MkdirTemp("", "nomad-packfile-rollback-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(varFilesDir)
+	err = history.WriteVarFiles(varFilesDir, record.VarFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	release.Pack = Pack{Name: record.PackName}
+	if record.Registry != "" {
+		registry := RegistryNode{Name: record.Registry, URL: record.RegistryURL, NomadPackFile: release.NomadPackFile}
+		if record.Ref != "" {
+			registry.Ref = &record.Ref
+		}
+		err = release.NomadPackFile.addRegistry(registry, release)
+		if err != nil {
+			return nil, err
+		}
+		release.Pack.Registry = &registry
+	}
+	release.Vars = vars
+	release.VarFiles = []string{}
+	for _, varFile := range record.VarFiles {
+		release.VarFiles = append(release.VarFiles, varFile.Name)
+	}
+	release.varFilesDir = varFilesDir
+	release.rollbackOf = revision
+
+	pterm.DefaultBasicText.Printf("Rolling back release %s in environment %s to revision %d (%s).\n", release.Name, release.Environment, revision, record.Timestamp.Local().Format(time.RFC3339))
+	return release.Run()
 }
 
-func (n *NomadPackFile) NomadPack() (*nomadpack.NomadPack, error) {
-	return nomadpack.New(n.config.NomadPackBinary, n.logger)
+// History returns the recorded runs of the release, oldest first.
+func (release ReleaseNode) History() ([]history.Record, error) {
+	store, err := history.New(release.Connection)
+	if err != nil {
+		return nil, err
+	}
+	return store.List(release.Name, release.Environment)
 }
 
-func (n *NomadPackFile) Plan() error {
-	for _, registry := range n.registries {
-		err := registry.Plan()
+// Render renders the release templates. When outputDir is not empty the rendered
+// templates are written to outputDir/<environment>/<release> instead of being printed.
+func (release ReleaseNode) Render(outputDir string) (*nomadpack.Result, error) {
+	invocation, remove, err := release.invocation()
+	if err != nil {
+		return nil, err
+	}
+	defer remove()
+	if outputDir != "" {
+		invocation.ToDir, err = filepath.Abs(filepath.Join(outputDir, release.Environment, release.Name))
+		if err != nil {
+			return nil, err
+		}
+		err = os.MkdirAll(invocation.ToDir, 0755)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("could not create output directory %s: %w", invocation.ToDir, err)
 		}
 	}
+
+	return release.NomadPackFile.runner.Render(invocation)
+}
+
+// Destroy stops and purges the jobs of the release.
+func (release ReleaseNode) Destroy() (*nomadpack.Result, error) {
+	invocation, remove, err := release.invocation()
+	if err != nil {
+		return nil, err
+	}
+	defer remove()
+	return release.NomadPackFile.runner.Destroy(invocation)
+}
+
+// jobIDs returns the IDs of the jobs of the release pack.
+func (release ReleaseNode) jobIDs(runner nomadpack.Runner) ([]nomadpack.JobID, error) {
+	invocation, remove, err := release.invocation()
+	if err != nil {
+		return nil, err
+	}
+	defer remove()
+	return runner.JobIDs(invocation)
+}
+
+// label identifies the release in the output.
+func (release ReleaseNode) label() string {
+	return release.Environment + "/" + release.Name
+}
+
+// invocation returns what nomad-pack needs to operate on the release. The HCL secrets files are
+// written to temporary var files, which go after the other ones and must be deleted with remove
+// once nomad-pack exits.
+func (release ReleaseNode) invocation() (invocation nomadpack.Invocation, remove func(), err error) {
+	secretVarFiles, remove, err := secrets.WriteVarFiles(release.secrets)
+	if err != nil {
+		return nomadpack.Invocation{}, remove, fmt.Errorf("could not write the secrets var files: %w", err)
+	}
+	return nomadpack.Invocation{
+		WorkDir:        release.workDir,
+		Pack:           release.Pack.nomadPack(),
+		VarFiles:       slices.Concat(release.varFilePaths(), secretVarFiles),
+		Vars:           release.Vars,
+		Connection:     release.Connection,
+		EnvPassthrough: release.EnvPassthrough,
+		Env:            release.Env,
+		Label:          release.label(),
+	}, remove, nil
+}
+
+// cluster returns the Nomad cluster the release is deployed to.
+func (release ReleaseNode) cluster() *nomadpack.Cluster {
+	return nomadpack.NewCluster(release.Connection, release.label())
+}
+
+// New returns the NomadPackFile of config, whose nomad-pack operations are run by runner.
+func New(config configpkg.Config, runner nomadpack.Runner, logger *zap.Logger) *NomadPackFile {
+	return &NomadPackFile{
+		config:          config,
+		runner:          runner,
+		logger:          logger,
+		registries:      make(map[string]RegistryNode),
+		addedRegistries: make(map[registryKey]error),
+	}
+}
+
+// NewRunner returns the nomad-pack runner configured in config.
+func NewRunner(config configpkg.Config, logger *zap.Logger) (nomadpack.Runner, error) {
+	options := nomadpack.RunnerOptions{
+		BinaryPath:        config.NomadPackBinary,
+		VersionConstraint: config.NomadPackVersion,
+		EnvPassthrough:    envPassthrough(config.EnvPassthrough),
+		Quiet:             config.Quiet,
+	}
+	return nomadpack.NewRunner(options, logger)
+}
+
+func envPassthrough(config configpkg.EnvPassthroughConfig) nomadpack.EnvPassthrough {
+	return nomadpack.EnvPassthrough{Allow: config.Allow, Deny: config.Deny}
+}
+
+// Plan executes a plan for every release. Every release is planned even if some of them
+// fail, the results are returned in the order the releases were compiled.
+func (n *NomadPackFile) Plan() ([]ReleaseResult, error) {
+	return n.forEachRelease(false, func(release ReleaseNode) (*nomadpack.Result, error) {
+		return release.Plan()
+	})
+}
+
+// Render renders every release, see ReleaseNode.Render for the meaning of outputDir.
+func (n *NomadPackFile) Render(outputDir string) ([]ReleaseResult, error) {
+	return n.forEachRelease(false, func(release ReleaseNode) (*nomadpack.Result, error) {
+		return release.Render(outputDir)
+	})
+}
+
+// Run deploys every release, in the order they were compiled. It stops at the first release that
+// fails, as later releases may depend on it, e.g. an app on its database.
+func (n *NomadPackFile) Run() ([]ReleaseResult, error) {
+	return n.forEachRelease(true, func(release ReleaseNode) (*nomadpack.Result, error) {
+		return release.Run()
+	})
+}
+
+// Destroy destroys the jobs of every release. Like Run, it stops at the first release that fails.
+func (n *NomadPackFile) Destroy() ([]ReleaseResult, error) {
+	return n.forEachRelease(true, func(release ReleaseNode) (*nomadpack.Result, error) {
+		return release.Destroy()
+	})
+}
+
+// Release returns the compiled release with the given name in environment.
+func (n *NomadPackFile) Release(environment, name string) (ReleaseNode, error) {
+	environments := []string{}
 	for _, release := range n.releases {
-		err := release.Plan()
-		if err != nil {
-			return err
+		if release.Name != name {
+			continue
 		}
+		if release.Environment == environment {
+			return release, nil
+		}
+		environments = append(environments, release.Environment)
 	}
 
-	return nil
+	if len(environments) == 0 {
+		return ReleaseNode{}, fmt.Errorf("release %s not found", name)
+	}
+	if environment == "" && len(environments) == 1 {
+		return n.Release(environments[0], name)
+	}
+	return ReleaseNode{}, fmt.Errorf("release %s not found in environment %q, it is defined in: %s", name, environment, strings.Join(environments, ", "))
 }
 
-func (n *NomadPackFile) Render() error {
-	for _, registry := range n.registries {
-		err := registry.Plan()
-		if err != nil {
-			return err
+// Rollback runs the release with the given name in environment again with the inputs of a
+// previous revision, see ReleaseNode.Rollback.
+func (n *NomadPackFile) Rollback(environment, name string, revision int) ([]ReleaseResult, error) {
+	release, err := n.Release(environment, name)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := release.Rollback(revision)
+	if err != nil {
+		err = fmt.Errorf("release %s in environment %s: %w", release.Name, release.Environment, err)
+	}
+	return []ReleaseResult{newReleaseResult(release, result, err)}, err
+}
+
+// Preflight checks, once per environment (and distinct Nomad connection), that the clusters can be
+// deployed to. Every problem of every environment is printed and an error returned if there are any.
+func (n *NomadPackFile) Preflight() error {
+	return n.preflight(nomadpack.Preflight)
+}
+
+// CheckConnections checks, like Preflight, that the clusters can be reached with a valid token,
+// without requiring it to be allowed to deploy.
+func (n *NomadPackFile) CheckConnections() error {
+	return n.preflight(nomadpack.CheckConnection)
+}
+
+func (n *NomadPackFile) preflight(check func(nomadpack.Connection) nomadpack.PreflightReport) error {
+	type target struct {
+		environment string
+		connection  nomadpack.Connection
+	}
+	checked := map[target]bool{}
+	var errs []error
+
+	for _, release := range n.releases {
+		t := target{environment: release.Environment, connection: release.Connection}
+		if checked[t] {
+			continue
 		}
+		checked[t] = true
+
+		report := check(release.Connection)
+		for _, warning := range report.Warnings {
+			pterm.Warning.Printf("Environment %s: %v\n", release.Environment, warning)
+		}
+		if len(report.Problems) == 0 {
+			version := report.ServerVersion
+			if version == "" {
+				version = "(unknown version)"
+			}
+			pterm.Success.Printf("Environment %s: Nomad %s at %s is ready.\n", release.Environment, version, release.Connection.Address)
+			continue
+		}
+		for _, problem := range report.Problems {
+			pterm.Error.Printf("Environment %s: %v\n", release.Environment, problem)
+			errs = append(errs, fmt.Errorf("environment %s: %w", release.Environment, problem))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ReleaseStatus is the live state in Nomad of the jobs of a release.
+type ReleaseStatus struct {
+	Environment string                `json:"environment"`
+	Release     string                `json:"release"`
+	Jobs        []nomadpack.JobStatus `json:"jobs"`
+	Error       string                `json:"error,omitempty"`
+}
+
+// Status fetches the live state of the jobs of the release pack.
+func (release ReleaseNode) Status() ([]nomadpack.JobStatus, error) {
+	jobIDs, err := release.jobIDs(release.NomadPackFile.runner)
+	if err != nil {
+		return nil, fmt.Errorf("could not find the jobs of the release: %w", err)
 	}
+	return release.cluster().JobStatuses(jobIDs)
+}
+
+// Status returns the live state of every release. Releases whose state cannot be fetched are
+// included with their error, which is also returned.
+func (n *NomadPackFile) Status() ([]ReleaseStatus, error) {
+	statuses := []ReleaseStatus{}
+	var errs []error
 	for _, release := range n.releases {
-		err := release.Render()
+		status := ReleaseStatus{Environment: release.Environment, Release: release.Name}
+		err := n.addPackRegistry(release)
+		if err == nil {
+			status.Jobs, err = release.Status()
+		}
 		if err != nil {
-			return err
+			status.Error = err.Error()
+			errs = append(errs, fmt.Errorf("release %s in environment %s: %w", release.Name, release.Environment, err))
 		}
+		statuses = append(statuses, status)
 	}
 
-	return nil
+	return statuses, errors.Join(errs...)
+}
+
+// addPackRegistry adds the registry of the release pack, if it comes from one.
+func (n *NomadPackFile) addPackRegistry(release ReleaseNode) error {
+	if release.Pack.Registry == nil {
+		return nil
+	}
+	return n.addRegistry(*release.Pack.Registry, release)
+}
+
+// addRegistry adds the registry to the nomad-pack cache, at most once per ref in a run. It is not
+// added when nomad-pack already has the ref cached, unless config.RefreshRegistries is set.
+// nomad-pack gets the env-passthrough and env of release, the first one needing the registry, e.g.
+// SSH_AUTH_SOCK to clone a private repository.
+func (n *NomadPackFile) addRegistry(registry RegistryNode, release ReleaseNode) error {
+	key := registry.key()
+	if err, added := n.addedRegistries[key]; added {
+		return err
+	}
+
+	if !n.config.RefreshRegistries {
+		url, cached := n.cachedRegistry(key)
+		if cached && (url == "" || url == registry.URL) {
+			pterm.DefaultBasicText.Printf("Registry %s@%s is already cached.\n", key.name, key.ref)
+			n.addedRegistries[key] = nil
+			return nil
+		}
+	}
+
+	err := n.runner.AddRegistry(nomadpack.Registry{
+		Name:           registry.Name,
+		URL:            registry.URL,
+		Ref:            registry.ref(),
+		Target:         registry.Target,
+		EnvPassthrough: release.EnvPassthrough,
+		Env:            release.Env,
+	})
+	if err != nil {
+		err = fmt.Errorf("could not add registry %s: %w", registry.Name, err)
+	}
+	n.addedRegistries[key] = err
+	return err
 }
 
-func (n *NomadPackFile) Run() error {
-	for _, registry := range n.registries {
-		err := registry.Plan()
+// cachedRegistry returns whether nomad-pack has the registry ref cached, and its url if known. The
+// cache is listed once, failing to list it only means that the registries are added again.
+func (n *NomadPackFile) cachedRegistry(key registryKey) (string, bool) {
+	if n.cachedRegistries == nil {
+		n.cachedRegistries = map[registryKey]string{}
+		registries, err := n.runner.Registries()
 		if err != nil {
-			return err
+			n.logger.Debug("Could not list the cached registries", zap.Error(err))
+		}
+		for _, registry := range registries {
+			node := RegistryNode{Name: registry.Name, Ref: registry.Ref}
+			n.cachedRegistries[node.key()] = registry.URL
 		}
 	}
+	url, cached := n.cachedRegistries[key]
+	return url, cached
+}
+
+// forEachRelease calls fn for every release, after adding the registry of its pack, collecting the
+// results. The returned error is not nil if any of the releases failed. With failFast, the
+// releases after the first one that fails are skipped and left out of the results.
+func (n *NomadPackFile) forEachRelease(failFast bool, fn func(release ReleaseNode) (*nomadpack.Result, error)) ([]ReleaseResult, error) {
+	results := []ReleaseResult{}
+	var errs []error
 	for _, release := range n.releases {
-		err := release.Run()
+		var result *nomadpack.Result
+		err := n.addPackRegistry(release)
+		if err == nil {
+			result, err = fn(release)
+		}
 		if err != nil {
-			return err
+			errs = append(errs, fmt.Errorf("release %s in environment %s: %w", release.Name, release.Environment, err))
+		}
+		results = append(results, newReleaseResult(release, result, err))
+		if err != nil && failFast {
+			if skipped := len(n.releases) - len(results); skipped > 0 {
+				pterm.Warning.Printf("Stopped after release %s in environment %s failed, %d release(s) skipped\n", release.Name, release.Environment, skipped)
+			}
+			break
 		}
 	}
 
-	return nil
+	return results, errors.Join(errs...)
 }
 
 type templateEnvironmentContext struct {
@@ -175,9 +735,21 @@ type templateEnvironmentContext struct {
 type templateContext struct {
 	Environment templateEnvironmentContext
 	Env         map[string]string
+	// Secrets are the values of the YAML secrets files of the release.
+	Secrets map[string]any
 }
 
+// Compile builds the registries and the releases of every environment from the config, pinning
+// the registry refs to the commits of packfile.lock.
 func (n *NomadPackFile) Compile() error {
+	err := n.compile()
+	if err != nil {
+		return err
+	}
+	return n.applyLock()
+}
+
+func (n *NomadPackFile) compile() error {
 	for _, registryConfig := range n.config.Registries {
 		if n.registries[registryConfig.Name].Name != "" {
 			pterm.Warning.Printf("Registry %s already exists, skipping", registryConfig.Name)
@@ -193,86 +765,77 @@ func (n *NomadPackFile) Compile() error {
 		}
 	}
 
-	for name, environmentRelease := range n.config.Environments {
+	// Files shared by several releases are only decrypted once.
+	decrypted := map[string]secrets.File{}
+	// Environments are compiled in the order of their names, so that Run and Destroy, which stop at
+	// the first failure, go through them in the same order every time.
+	environments := make([]string, 0, len(n.config.Environments))
+	for name := range n.config.Environments {
+		environments = append(environments, name)
+	}
+	slices.Sort(environments)
+	for _, name := range environments {
+		environmentRelease := n.config.Environments[name]
 		for _, release := range n.config.Releases {
 			workDir := n.config.WorkDir()
-			fmt.Println("Release: ", release.Name)
-			fmt.Println("Environment: ", name)
-			fmt.Println("Release.Envirnoments: ", release.Environments)
-			fmt.Println("workDir: ", workDir)
+			n.logger.Debug("Compiling release", zap.String("release", release.Name), zap.String("environment", name), zap.Strings("release.environments", release.Environments), zap.String("workDir", workDir))
 
 			if release.Environments != nil && !slices.Contains(release.Environments, name) {
 				continue
 			}
 
-			if environmentRelease.NomadAddr != "" {
-				release.NomadAddr = environmentRelease.NomadAddr
+			// Relative paths are resolved against the directory of the file that declared them.
+			environmentPaths := func(paths []string) declaredPaths {
+				return declaredPaths{dir: environmentRelease.Dir(workDir), paths: paths}
 			}
-			if environmentRelease.NomadToken != "" {
-				release.NomadToken = environmentRelease.NomadToken
+			releasePaths := func(paths []string) declaredPaths {
+				return declaredPaths{dir: release.Dir(workDir), paths: paths}
 			}
-			if release.EnvironmentFiles != nil {
-				for _, envFile := range release.EnvironmentFiles {
-					filePath := workDir + "/" + envFile
-					err := godotenv.Load(filePath)
-					if err != nil {
-						log.Fatalf("Error reading environment file %s: %s", envFile, err)
-					}
-				}
+
+			fileEnv, err := readEnvironmentFiles(environmentPaths(environmentRelease.EnvironmentFiles), releasePaths(release.EnvironmentFiles))
+			if err != nil {
+				return fmt.Errorf("release %s: %w", release.Name, err)
 			}
 
+			// The environment files of the release take precedence over the process environment.
+			templateEnv := environmentToHash()
+			maps.Copy(templateEnv, fileEnv)
 			context := templateContext{
 				Environment: templateEnvironmentContext{
 					Name: name,
 				},
-				Env: environmentToHash(),
+				Env: templateEnv,
 			}
-			var pack Pack
-
-			if strings.HasPrefix(release.Pack, "registry://") {
-				release.Pack = strings.TrimPrefix(release.Pack, "registry://")
-				splitPack := strings.Split(release.Pack, "/")
-				if len(splitPack) != 2 {
-					log.Fatalf("Invalid pack name: %s", release.Pack)
-				}
-
-				if n.registries[splitPack[0]].Name == "" {
-					log.Fatalf("Registry %s not found", splitPack[0])
-				}
-
-				registry := n.registries[splitPack[0]]
-				pack = Pack{
-					Registry: &registry,
-					Name:     splitPack[1],
-				}
-			} else {
-				pack = Pack{
-					Name: release.Pack,
-				}
+			pack, err := n.compilePack(environmentRelease, release, context)
+			if err != nil {
+				return fmt.Errorf("release %s: %w", release.Name, err)
+			}
+			if n.packsOnly {
+				n.releases = append(n.releases, ReleaseNode{Name: release.Name, Environment: name, Pack: pack, NomadPackFile: n})
+				continue
 			}
 
-			var err error
-			release.NomadAddr, err = execTemplate(release.NomadAddr, context)
+			secretFiles, err := n.readSecrets(decrypted, context, environmentPaths(environmentRelease.Secrets), releasePaths(release.Secrets))
 			if err != nil {
-				log.Fatalf("Error interpreting template in nomad-addr: %s, err: %s.", release.NomadAddr, err)
-				panic(err)
-
+				return fmt.Errorf("release %s: %w", release.Name, err)
 			}
-			release.NomadToken, err = execTemplate(release.NomadToken, context)
+			context.Secrets = secrets.Merge(secretFiles)
+
+			connection, err := compileConnection(release, environmentRelease, workDir, context)
 			if err != nil {
-				log.Fatalf("Error interpreting template in nomad-addr: %s, err: %s.", release.NomadAddr, err)
-				panic(err)
+				return fmt.Errorf("release %s: could not compile the Nomad connection: %w", release.Name, err)
 			}
+			redact.Add(connection.Token)
 
 			newVarFiles := []string{}
 			for _, varFile := range release.VarFiles {
 				newVarFile, err := execTemplate(varFile, context)
 				if err != nil {
-					panic(err)
+					return fmt.Errorf("release %s: could not interpret template in var file %s: %w", release.Name, varFile, err)
 				}
-				filePath := workDir + "/" + newVarFile
+				filePath := resolvePath(release.Dir(workDir), newVarFile)
 				if _, err := os.Stat(filePath); err == nil {
-					newVarFiles = append(newVarFiles, newVarFile)
+					newVarFiles = append(newVarFiles, workDirPath(workDir, release.Dir(workDir), newVarFile))
 				} else {
 					pterm.Warning.Printf("Var file %s not found, skipping", filePath)
 				}
@@ -282,20 +845,65 @@ func (n *NomadPackFile) Compile() error {
 			for key, bar := range release.Vars {
 				newVar, err := execTemplate(bar, context)
 				if err != nil {
-					panic(err)
+					return fmt.Errorf("release %s: could not interpret template in var %s: %w", release.Name, key, err)
 				}
 				newVars[key] = newVar
+				if slices.Contains(release.SensitiveVars, key) {
+					redact.Add(newVar)
+				}
+			}
+
+			passthrough := envPassthrough(n.config.EnvPassthrough).
+				Merge(envPassthrough(environmentRelease.EnvPassthrough)).
+				Merge(envPassthrough(release.EnvPassthrough))
+			err = passthrough.Validate()
+			if err != nil {
+				return fmt.Errorf("release %s: %w", release.Name, err)
+			}
+
+			// Variables set in the release take precedence over the ones set in the environment, and
+			// both over the ones of the environment files.
+			newEnv := maps.Clone(fileEnv)
+			for _, env := range []map[string]string{environmentRelease.Env, release.Env} {
+				for key, value := range env {
+					newValue, err := execTemplate(value, context)
+					if err != nil {
+						return fmt.Errorf("release %s: could not interpret template in env %s: %w", release.Name, key, err)
+					}
+					newEnv[key] = newValue
+				}
+			}
+
+			wait, waitTimeout, err := compileWait(n.config, environmentRelease, release)
+			if err != nil {
+				return fmt.Errorf("release %s: %w", release.Name, err)
+			}
+			rollbackOnFailure := false
+			for _, c := range []configpkg.ReleaseConfig{environmentRelease, release} {
+				if c.RollbackOnFailure != nil {
+					rollbackOnFailure = *c.RollbackOnFailure
+				}
 			}
 
 			releaseNode := ReleaseNode{
-				Name:          release.Name,
-				Pack:          pack,
-				VarFiles:      newVarFiles,
-				workDir:       workDir,
-				NomadPackFile: n,
-				NomadAddr:     release.NomadAddr,
-				NomadToken:    release.NomadToken,
-				Vars:          newVars,
+				Name:              release.Name,
+				Environment:       name,
+				Pack:              pack,
+				VarFiles:          newVarFiles,
+				workDir:           workDir,
+				NomadPackFile:     n,
+				Connection:        connection,
+				EnvPassthrough:    passthrough,
+				Env:               newEnv,
+				Vars:              newVars,
+				Wait:              wait,
+				WaitTimeout:       waitTimeout,
+				RollbackOnFailure: rollbackOnFailure,
+				secrets:           secretFiles,
+			}
+			// Rolling back needs the outcome of the deployment, so it implies waiting for it.
+			if rollbackOnFailure {
+				releaseNode.Wait = true
 			}
 
 			n.releases = append(n.releases, releaseNode)
@@ -306,6 +914,216 @@ func (n *NomadPackFile) Compile() error {
 	return nil
 }
 
+// applyLock pins the registry refs used by the releases to the commits of packfile.lock. Refs that
+// are not locked are used as they are, unless config.Frozen is set, which requires an up to date
+// lock file.
+func (n *NomadPackFile) applyLock() error {
+	refs := n.registryRefs()
+	path := lock.Path(n.config.WorkDir())
+	lockFile, err := lock.Read(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if n.config.Frozen && len(refs) > 0 {
+			return fmt.Errorf("%s not found, run nomad-packfile lock to create it", lock.FileName)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	stale := lockFile.Stale(refs)
+	if len(stale) > 0 {
+		if n.config.Frozen {
+			return fmt.Errorf("%s is out of date for registries %s, run nomad-packfile lock to update it", lock.FileName, strings.Join(stale, ", "))
+		}
+		pterm.Warning.Printf("%s is out of date for registries %s, run nomad-packfile lock to update it\n", lock.FileName, strings.Join(stale, ", "))
+	}
+
+	for _, release := range n.releases {
+		registry := release.Pack.Registry
+		if registry == nil {
+			continue
+		}
+		if locked, found := lockFile.Find(registry.config()); found {
+			registry.Commit = locked.Commit
+		}
+	}
+	return nil
+}
+
+// registryRefs returns every registry at every ref it is used at: the ones of the registries
+// section and the ones the releases override.
+func (n *NomadPackFile) registryRefs() []configpkg.RegistryConfig {
+	refs := []configpkg.RegistryConfig{}
+	seen := map[registryKey]bool{}
+	add := func(registry configpkg.RegistryConfig) {
+		key := registryKey{name: registry.Name, ref: lock.Ref(registry)}
+		if !seen[key] {
+			seen[key] = true
+			refs = append(refs, registry)
+		}
+	}
+
+	for _, registry := range n.config.Registries {
+		add(registry)
+	}
+	for _, release := range n.releases {
+		if release.Pack.Registry != nil {
+			add(release.Pack.Registry.config())
+		}
+	}
+	return refs
+}
+
+// Lock resolves every registry ref used by the packfile of config to a commit, see lock.New.
+func Lock(config configpkg.Config, logger *zap.Logger) (*lock.Lock, error) {
+	n := New(config, nil, logger)
+	n.packsOnly = true
+	err := n.compile()
+	if err != nil {
+		return nil, err
+	}
+	return lock.New(n.registryRefs(), config.WorkDir())
+}
+
+// compilePack parses the pack of the release. Local packs are resolved by resolveLocalPack. Packs of a registry, or of a git repository, which
+// is added as a registry named after its url, use the ref given by compileRef when there is one.
+func (n *NomadPackFile) compilePack(environment, release configpkg.ReleaseConfig, context templateContext) (Pack, error) {
+	reference, err := ParsePackReference(release.Pack)
+	if err != nil {
+		return Pack{}, err
+	}
+
+	var registry RegistryNode
+	switch reference.Kind {
+	case PackReferenceLocal:
+		if release.Ref != "" {
+			return Pack{}, fmt.Errorf("ref can only be set for packs of a registry")
+		}
+		packPath, err := resolveLocalPack(reference.Path, release, n.config.WorkDir())
+		if err != nil {
+			return Pack{}, err
+		}
+		return Pack{Name: packPath, Reference: reference}, nil
+	case PackReferenceRegistry:
+		registry = n.registries[reference.Registry]
+		if registry.Name == "" {
+			return Pack{}, fmt.Errorf("registry %s not found", reference.Registry)
+		}
+	case PackReferenceGit:
+		// nomad-pack fetches registries with go-getter, which needs git:: to clone http urls.
+		registry = RegistryNode{Name: reference.Registry, URL: "git::" + reference.URL, NomadPackFile: n}
+	}
+
+	ref, err := compileRef(reference.Ref, environment, release, context)
+	if err != nil {
+		return Pack{}, err
+	}
+	if ref != "" {
+		registry.Ref = &ref
+	}
+	return Pack{Name: reference.Path, Registry: &registry, Reference: reference}, nil
+}
+
+// compileRef returns the ref overriding the one of the registry for a release pack, if any: the
+// one of the pack (e.g. registry://<registry>/<pack>@<ref>), the one of the release or the one of the
+// environment, in that order of precedence.
+func compileRef(packRef string, environment, release configpkg.ReleaseConfig, context templateContext) (string, error) {
+	for _, ref := range []string{packRef, release.Ref, environment.Ref} {
+		if ref == "" {
+			continue
+		}
+		compiled, err := execTemplate(ref, context)
+		if err != nil {
+			return "", fmt.Errorf("could not interpret template in ref %s: %w", ref, err)
+		}
+		return compiled, nil
+	}
+	return "", nil
+}
+
+// compileWait returns the wait settings of a release. Settings in the release take precedence over the ones
+// in the environment, which take precedence over the command line flags.
+func compileWait(config configpkg.Config, environment, release configpkg.ReleaseConfig) (wait bool, timeout time.Duration, err error) {
+	wait, timeout = config.Wait, config.WaitTimeout
+	for _, c := range []configpkg.ReleaseConfig{environment, release} {
+		if c.Wait != nil {
+			wait = *c.Wait
+		}
+		if c.WaitTimeout != "" {
+			timeout, err = time.ParseDuration(c.WaitTimeout)
+			if err != nil {
+				return false, 0, fmt.Errorf("invalid wait-timeout %q: %w", c.WaitTimeout, err)
+			}
+		}
+	}
+	if timeout <= 0 {
+		timeout = defaultWaitTimeout
+	}
+	return wait, timeout, nil
+}
+
+// declaredPaths are paths of the packfile, relative ones to dir, the directory of the file that
+// declared them.
+type declaredPaths struct {
+	dir   string
+	paths []string
+}
+
+// workDirPath returns path, relative to dir, as it has to be passed to nomad-pack, which runs in
+// workDir. Absolute paths are kept.
+func workDirPath(workDir, dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	relative, err := filepath.Rel(workDir, filepath.Join(dir, path))
+	if err != nil {
+		return filepath.Join(dir, path)
+	}
+	return relative
+}
+
+// readEnvironmentFiles reads the environment files of every list in order. Variables of a file take
+// precedence over the ones of the previous files.
+func readEnvironmentFiles(lists ...declaredPaths) (map[string]string, error) {
+	env := map[string]string{}
+	for _, list := range lists {
+		for _, file := range list.paths {
+			fileEnv, err := godotenv.Read(resolvePath(list.dir, file))
+			if err != nil {
+				return nil, fmt.Errorf("could not read environment file %s: %w", file, err)
+			}
+			maps.Copy(env, fileEnv)
+		}
+	}
+	return env, nil
+}
+
+// readSecrets decrypts the secrets files of every list in order. The paths can use templates,
+// without .Secrets, and decrypted caches the files by path.
+func (n *NomadPackFile) readSecrets(decrypted map[string]secrets.File, context templateContext, lists ...declaredPaths) ([]secrets.File, error) {
+	files := []secrets.File{}
+	for _, list := range lists {
+		for _, name := range list.paths {
+			path, err := execTemplate(name, context)
+			if err != nil {
+				return nil, fmt.Errorf("could not interpret template in secrets file %s: %w", name, err)
+			}
+			path = resolvePath(list.dir, path)
+			file, found := decrypted[path]
+			if !found {
+				file, err = secrets.Read(path)
+				if err != nil {
+					return nil, err
+				}
+				decrypted[path] = file
+			}
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
 func environmentToHash() (result map[string]string) {
 	result = make(map[string]string, len(os.Environ()))
 	for _, env := range os.Environ() {
@@ -315,8 +1133,17 @@ func environmentToHash() (result map[string]string) {
 	return
 }
 
+// templateFuncs are the functions available in templates.
+var templateFuncs = template.FuncMap{
+	// sensitive marks the value as a secret so it is redacted from every output, e.g. {{ .Env.DB_PASSWORD | sensitive }}.
+	"sensitive": func(value string) string {
+		redact.Add(value)
+		return value
+	},
+}
+
 func execTemplate(tmpl string, context templateContext) (string, error) {
-	t, err := template.New("nomad-pack-template").Option("missingkey=zero").Parse(tmpl)
+	t, err := template.New("nomad-pack-template").Option("missingkey=zero").Funcs(templateFuncs).Parse(tmpl)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,1114 @@
+package nomadpackfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	configpkg "github.com/magec/nomad-packfile/internal/config"
+	"github.com/magec/nomad-packfile/internal/history"
+	"github.com/magec/nomad-packfile/internal/lock"
+	"github.com/magec/nomad-packfile/internal/nomadpack"
+	"github.com/magec/nomad-packfile/internal/nomadpack/nomadpacktest"
+	"github.com/magec/nomad-packfile/internal/redact"
+	"github.com/magec/nomad-packfile/internal/secrets/secretstest"
+	"github.com/magec/nomad-packfile/test"
+	"github.com/magec/nomad-packfile/test/nomadtest"
+	"github.com/pterm/pterm"
+	"gopkg.in/yaml.v3"
+)
+
+const lockedCommit = "0123456789abcdef0123456789abcdef01234567"
+
+const testPackfile = `
+registries:
+  - name: myorg
+    url: github.com/myorg/packs
+    ref: v1.0.0
+environments:
+  staging:
+    nomad-addr: https://staging.example.com:4646
+    env:
+      REGION: eu
+  production:
+    nomad-addr: https://production.example.com:4646
+    nomad-namespace: prod
+    wait: true
+releases:
+  - name: app
+    pack: registry://myorg/app
+    var-files:
+      - "{{ .Environment.Name }}.hcl"
+    vars:
+      environment: "{{ .Environment.Name }}"
+    env:
+      REGION: us
+  - name: worker
+    pack: ./packs/worker
+    environments:
+      - staging
+`
+
+func TestCompileReleasesPerEnvironment(t *testing.T) {
+	nomadPackFile, err := compile(t, testPackfile, nomadpacktest.New(), "staging.hcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := []string{}
+	for _, release := range nomadPackFile.releases {
+		labels = append(labels, release.label())
+	}
+	// Environments are compiled in the order of their names, releases in the order they are declared.
+	expected := []string{"production/app", "staging/app", "staging/worker"}
+	if !slices.Equal(labels, expected) {
+		t.Errorf("compiled releases %v, expected %v in this order", labels, expected)
+	}
+}
+
+func TestCompileRelease(t *testing.T) {
+	nomadPackFile, err := compile(t, testPackfile, nomadpacktest.New(), "staging.hcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staging, err := nomadPackFile.Release("staging", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if staging.Connection.Address != "https://staging.example.com:4646" {
+		t.Errorf("expected the connection of the environment, got %q", staging.Connection.Address)
+	}
+	if staging.Vars["environment"] != "staging" {
+		t.Errorf("expected the vars to be templated, got %v", staging.Vars)
+	}
+	if staging.Env["REGION"] != "us" {
+		t.Errorf("expected the release env to take precedence, got %v", staging.Env)
+	}
+	if !slices.Equal(staging.VarFiles, []string{"staging.hcl"}) {
+		t.Errorf("expected the existing var file, got %v", staging.VarFiles)
+	}
+	if staging.Wait {
+		t.Error("expected staging not to wait")
+	}
+
+	production, err := nomadPackFile.Release("production", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if production.Connection.Namespace != "prod" {
+		t.Errorf("expected the namespace of the environment, got %q", production.Connection.Namespace)
+	}
+	if len(production.VarFiles) != 0 {
+		t.Errorf("expected the missing var file to be skipped, got %v", production.VarFiles)
+	}
+	if !production.Wait || production.WaitTimeout != defaultWaitTimeout {
+		t.Errorf("expected production to wait %s, got %v %s", defaultWaitTimeout, production.Wait, production.WaitTimeout)
+	}
+}
+
+func TestCompileEnvironmentFilesAreScopedToTheRelease(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"staging.env": "REGION=eu\nTIER=staging\nLEVEL=environment\n",
+		"app.env":     "DB_PASSWORD=secret\nLEVEL=release\n",
+	}
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	nomadPackFile, err := compileDir(t, dir, `
+environments:
+  staging:
+    environment-files:
+      - staging.env
+    env:
+      TIER: from-env
+releases:
+  - name: app
+    pack: ./packs/app
+    environment-files:
+      - app.env
+    vars:
+      password: "{{ .Env.DB_PASSWORD }}"
+  - name: worker
+    pack: ./packs/worker
+    vars:
+      password: "{{ .Env.DB_PASSWORD }}"
+`, nomadpacktest.New(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := nomadPackFile.Release("staging", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"REGION": "eu", "TIER": "from-env", "LEVEL": "release", "DB_PASSWORD": "secret"}
+	for name, value := range expected {
+		if app.Env[name] != value {
+			t.Errorf("expected %s=%s in the env of app, got %v", name, value, app.Env)
+		}
+	}
+	if app.Vars["password"] != "secret" {
+		t.Errorf("expected the environment files in .Env, got %v", app.Vars)
+	}
+
+	worker, err := nomadPackFile.Release("staging", "worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := worker.Env["DB_PASSWORD"]; found || worker.Vars["password"] != "" {
+		t.Errorf("expected the environment files of app not to leak to worker, got %v %v", worker.Env, worker.Vars)
+	}
+	if worker.Env["REGION"] != "eu" {
+		t.Errorf("expected the environment files of the environment, got %v", worker.Env)
+	}
+	if _, found := os.LookupEnv("DB_PASSWORD"); found {
+		t.Error("expected the environment files not to be loaded into the process environment")
+	}
+}
+
+const secretsPackfile = `
+environments:
+  staging:
+    secrets:
+      - secrets/{{ .Environment.Name }}.yaml
+releases:
+  - name: app
+    pack: ./packs/app
+    secrets:
+      - secrets/app.hcl.age
+    vars:
+      password: "{{ .Secrets.db.password }}"
+      user: "{{ .Secrets.db.user }}"
+  - name: worker
+    pack: ./packs/worker
+`
+
+func TestCompileSecrets(t *testing.T) {
+	secretstest.Install(t)
+	dir := t.TempDir()
+	err := os.Mkdir(filepath.Join(dir, "secrets"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretstest.Encrypt(t, filepath.Join(dir, "secrets", "staging.yaml"), "db:\n  user: app\n  password: staging-s3cr3t\n")
+	secretstest.Encrypt(t, filepath.Join(dir, "secrets", "app.hcl.age"), "api_key = \"app-s3cr3t\"\n")
+
+	runner := nomadpacktest.New()
+	nomadPackFile, err := compileDir(t, dir, secretsPackfile, runner, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := nomadPackFile.Release("staging", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.Vars["password"] != "staging-s3cr3t" {
+		t.Errorf("expected the secrets in .Secrets, got %v", app.Vars)
+	}
+	for _, secret := range []string{"staging-s3cr3t", "app-s3cr3t"} {
+		if redact.String(secret) != redact.Mask {
+			t.Errorf("expected %s to be redacted", secret)
+		}
+	}
+	if redact.String("staging/app") != "staging/app" {
+		t.Errorf("expected short secrets, like the user app, not to be redacted from the labels")
+	}
+
+	_, err = nomadPackFile.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plan := range runner.CallsTo(nomadpacktest.OperationPlan) {
+		varFiles := plan.Invocation.VarFiles
+		if plan.Label() == "staging/worker" {
+			if len(varFiles) != 0 {
+				t.Errorf("expected no var files for worker, got %v", varFiles)
+			}
+			continue
+		}
+		if len(varFiles) != 1 || plan.VarFiles[varFiles[0]] != "api_key = \"app-s3cr3t\"\n" {
+			t.Fatalf("expected the decrypted var file of app, got %v", plan.VarFiles)
+		}
+		if _, err := os.Stat(varFiles[0]); !os.IsNotExist(err) {
+			t.Errorf("expected the decrypted var file to be removed after nomad-pack exits, got %v", err)
+		}
+	}
+}
+
+func TestCompileSecretsErrors(t *testing.T) {
+	secretstest.Install(t)
+	dir := t.TempDir()
+	_, err := compileDir(t, dir, secretsPackfile, nomadpacktest.New(), nil)
+	if err == nil || !strings.Contains(err.Error(), "secrets/staging.yaml") {
+		t.Errorf("expected an error reading the secrets file, got %v", err)
+	}
+
+	// Locking does not need the keys.
+	config := configpkg.Config{}
+	err = yaml.Unmarshal([]byte(secretsPackfile), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Path = filepath.Join(dir, "packfile.yaml")
+	_, err = Lock(config, test.GetLogger(t))
+	if err != nil {
+		t.Errorf("expected lock to skip the secrets, got %v", err)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	cases := map[string]string{
+		"unknown registry": `
+environments:
+  staging: {}
+releases:
+  - name: app
+    pack: registry://unknown/app
+`,
+		"invalid pack name": `
+registries:
+  - name: myorg
+    url: github.com/myorg/packs
+environments:
+  staging: {}
+releases:
+  - name: app
+    pack: registry://myorg
+`,
+		"invalid template": `
+environments:
+  staging: {}
+releases:
+  - name: app
+    pack: ./app
+    vars:
+      broken: "{{ .Environment.Name "
+`,
+		"invalid wait-timeout": `
+environments:
+  staging:
+    wait-timeout: soon
+releases:
+  - name: app
+    pack: ./app
+`,
+	}
+
+	for name, packfile := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := compile(t, packfile, nomadpacktest.New())
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), "release app") {
+				t.Errorf("expected the error to name the release, got %q", err)
+			}
+		})
+	}
+}
+
+func TestPlanAddsRegistriesOnceAndContinuesOnFailure(t *testing.T) {
+	runner := nomadpacktest.New()
+	runner.Errors["staging/app"] = errors.New("plan failed")
+	nomadPackFile, err := compile(t, testPackfile, runner, "staging.hcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := nomadPackFile.Plan()
+	if err == nil || !strings.Contains(err.Error(), "release app in environment staging") {
+		t.Fatalf("expected the error of staging/app, got %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected a result for every release, got %d", len(results))
+	}
+	for _, result := range results {
+		failed := result.Environment == "staging" && result.Release == "app"
+		if result.Failed() != failed {
+			t.Errorf("release %s/%s: expected failed to be %v", result.Environment, result.Release, failed)
+		}
+	}
+
+	adds := runner.CallsTo(nomadpacktest.OperationAddRegistry)
+	if len(adds) != 1 || adds[0].Registry.Name != "myorg" || *adds[0].Registry.Ref != "v1.0.0" {
+		t.Errorf("expected myorg@v1.0.0 to be added once, got %+v", adds)
+	}
+	for _, call := range runner.Calls() {
+		if call.Operation == nomadpacktest.OperationAddRegistry {
+			break
+		}
+		if call.Operation == nomadpacktest.OperationPlan && strings.HasSuffix(call.Invocation.Label, "/app") {
+			t.Errorf("expected the registry to be added before planning %s", call.Invocation.Label)
+		}
+	}
+	plans := runner.CallsTo(nomadpacktest.OperationPlan)
+	if len(plans) != 3 {
+		t.Fatalf("expected 3 plans, got %d", len(plans))
+	}
+	for _, plan := range plans {
+		if !strings.HasSuffix(plan.Invocation.Label, "/app") {
+			continue
+		}
+		pack := plan.Invocation.Pack
+		if pack.Name != "app" || pack.Registry != "myorg" || pack.Ref != "v1.0.0" {
+			t.Errorf("unexpected pack %+v", pack)
+		}
+	}
+}
+
+func TestRunAndDestroyStopAtTheFirstFailure(t *testing.T) {
+	packfile := `
+environments:
+  staging: {}
+releases:
+  - name: database
+    pack: ./packs/database
+  - name: app
+    pack: ./packs/app
+`
+	for operation, fn := range map[string]func(*NomadPackFile) ([]ReleaseResult, error){
+		nomadpacktest.OperationRun:     (*NomadPackFile).Run,
+		nomadpacktest.OperationDestroy: (*NomadPackFile).Destroy,
+	} {
+		runner := nomadpacktest.New()
+		runner.Errors["staging/database"] = errors.New(operation + " failed")
+		nomadPackFile, err := compile(t, packfile, runner)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := fn(nomadPackFile)
+		if err == nil || !strings.Contains(err.Error(), "release database in environment staging") {
+			t.Errorf("%s: expected the error of staging/database, got %v", operation, err)
+		}
+		if len(results) != 1 || !results[0].Failed() {
+			t.Errorf("%s: expected only the failed release in the results, got %+v", operation, results)
+		}
+		if calls := runner.CallsTo(operation); len(calls) != 1 {
+			t.Errorf("%s: expected the releases after the failed one to be skipped, got %d calls", operation, len(calls))
+		}
+	}
+}
+
+func TestRegistriesAreOnlyAddedWhenReferenced(t *testing.T) {
+	runner := nomadpacktest.New()
+	nomadPackFile, err := compile(t, testPackfile, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nomadPackFile.releases = slices.DeleteFunc(nomadPackFile.releases, func(release ReleaseNode) bool {
+		return release.Name != "worker"
+	})
+
+	_, err = nomadPackFile.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := runner.Calls(); len(calls) != 1 || calls[0].Operation != nomadpacktest.OperationPlan {
+		t.Errorf("expected only the plan of worker, got %+v", calls)
+	}
+}
+
+func TestRegistriesAreAddedWithTheEnvOfTheRelease(t *testing.T) {
+	runner := nomadpacktest.New()
+	nomadPackFile, err := compile(t, `
+registries:
+  - name: myorg
+    url: git@github.com:myorg/packs.git
+env-passthrough:
+  allow: [HTTPS_PROXY]
+environments:
+  staging:
+    env-passthrough:
+      allow: [SSH_AUTH_SOCK]
+releases:
+  - name: app
+    pack: registry://myorg/app
+    env:
+      GIT_SSH_COMMAND: ssh -i deploy_key
+`, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nomadPackFile.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	adds := runner.CallsTo(nomadpacktest.OperationAddRegistry)
+	if len(adds) != 1 {
+		t.Fatalf("expected myorg to be added once, got %+v", adds)
+	}
+	registry := adds[0].Registry
+	if !slices.Equal(registry.EnvPassthrough.Allow, []string{"HTTPS_PROXY", "SSH_AUTH_SOCK"}) {
+		t.Errorf("expected the env-passthrough of the release, got %v", registry.EnvPassthrough)
+	}
+	if registry.Env["GIT_SSH_COMMAND"] != "ssh -i deploy_key" {
+		t.Errorf("expected the env of the release, got %v", registry.Env)
+	}
+}
+
+func TestCachedRegistriesAreNotAdded(t *testing.T) {
+	ref := "v1.0.0"
+	runner := nomadpacktest.New()
+	runner.Cached = []nomadpack.Registry{{Name: "myorg", URL: "github.com/myorg/packs", Ref: &ref}}
+	nomadPackFile, err := compile(t, testPackfile, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nomadPackFile.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adds := runner.CallsTo(nomadpacktest.OperationAddRegistry); len(adds) != 0 {
+		t.Errorf("expected the cached registry not to be added, got %+v", adds)
+	}
+	if lists := runner.CallsTo(nomadpacktest.OperationRegistries); len(lists) != 1 {
+		t.Errorf("expected the cache to be listed once, got %d", len(lists))
+	}
+
+	runner = nomadpacktest.New()
+	runner.Cached = []nomadpack.Registry{{Name: "myorg", URL: "github.com/myorg/packs", Ref: &ref}}
+	nomadPackFile, err = compileDir(t, t.TempDir(), testPackfile, runner, func(config *configpkg.Config) { config.RefreshRegistries = true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = nomadPackFile.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adds := runner.CallsTo(nomadpacktest.OperationAddRegistry); len(adds) != 1 {
+		t.Errorf("expected --refresh-registries to add the registry once, got %+v", adds)
+	}
+	if lists := runner.CallsTo(nomadpacktest.OperationRegistries); len(lists) != 0 {
+		t.Errorf("expected --refresh-registries not to list the cache, got %d", len(lists))
+	}
+}
+
+func TestRegistryAddFailureFailsItsReleases(t *testing.T) {
+	runner := nomadpacktest.New()
+	runner.Errors["registry/myorg"] = errors.New("clone failed")
+	nomadPackFile, err := compile(t, testPackfile, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := nomadPackFile.Plan()
+	if err == nil || !strings.Contains(err.Error(), "could not add registry myorg") {
+		t.Fatalf("expected the registry error, got %v", err)
+	}
+	for _, result := range results {
+		if result.Failed() != (result.Release == "app") {
+			t.Errorf("release %s/%s: expected only the releases of myorg to fail", result.Environment, result.Release)
+		}
+	}
+	if adds := runner.CallsTo(nomadpacktest.OperationAddRegistry); len(adds) != 1 {
+		t.Errorf("expected the failed registry to be tried once, got %d", len(adds))
+	}
+}
+
+func TestRenderToOutputDir(t *testing.T) {
+	runner := nomadpacktest.New()
+	nomadPackFile, err := compile(t, testPackfile, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputDir := t.TempDir()
+	_, err = nomadPackFile.Render(outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, render := range runner.CallsTo(nomadpacktest.OperationRender) {
+		expected := filepath.Join(outputDir, render.Invocation.Label)
+		if render.Invocation.ToDir != expected {
+			t.Errorf("expected %s to be rendered to %s, got %s", render.Invocation.Label, expected, render.Invocation.ToDir)
+		}
+		if _, err := os.Stat(expected); err != nil {
+			t.Errorf("expected the output directory to be created: %v", err)
+		}
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	runner := nomadpacktest.New()
+	nomadPackFile, err := compile(t, testPackfile, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nomadPackFile.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if destroys := runner.CallsTo(nomadpacktest.OperationDestroy); len(destroys) != 3 {
+		t.Errorf("expected 3 destroys, got %d", len(destroys))
+	}
+}
+
+func TestCompileUsesLockedCommits(t *testing.T) {
+	dir := t.TempDir()
+	lockFile := lock.Lock{Registries: []lock.Registry{{Name: "myorg", URL: "github.com/myorg/packs", Ref: "v1.0.0", Commit: lockedCommit}}}
+	err := lockFile.Write(filepath.Join(dir, lock.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runner := nomadpacktest.New()
+	nomadPackFile, err := compileDir(t, dir, testPackfile, runner, func(config *configpkg.Config) { config.Frozen = true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	release, err := nomadPackFile.Release("staging", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if release.Pack.Ref() != lockedCommit {
+		t.Errorf("expected the pack to use the locked commit, got %s", release.Pack.Ref())
+	}
+
+	_, err = nomadPackFile.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := runner.CallsTo(nomadpacktest.OperationAddRegistry)[0].Registry
+	if *registry.Ref != lockedCommit {
+		t.Errorf("expected the registry to be added with the locked commit, got %s", *registry.Ref)
+	}
+}
+
+func TestCompileFrozen(t *testing.T) {
+	frozen := func(config *configpkg.Config) { config.Frozen = true }
+
+	_, err := compileDir(t, t.TempDir(), testPackfile, nomadpacktest.New(), frozen)
+	if err == nil || !strings.Contains(err.Error(), "packfile.lock not found") {
+		t.Errorf("expected a missing lock file error, got %v", err)
+	}
+
+	dir := t.TempDir()
+	lockFile := lock.Lock{Registries: []lock.Registry{{Name: "myorg", URL: "github.com/myorg/packs", Ref: "v0.9.0", Commit: lockedCommit}}}
+	err = lockFile.Write(filepath.Join(dir, lock.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = compileDir(t, dir, testPackfile, nomadpacktest.New(), frozen)
+	if err == nil || !strings.Contains(err.Error(), "out of date for registries myorg") {
+		t.Errorf("expected a stale lock file error, got %v", err)
+	}
+
+	nomadPackFile, err := compileDir(t, dir, testPackfile, nomadpacktest.New(), nil)
+	if err != nil {
+		t.Fatalf("expected a stale lock file to be ignored without --frozen: %v", err)
+	}
+	release, _ := nomadPackFile.Release("staging", "app")
+	if release.Pack.Ref() != "v1.0.0" {
+		t.Errorf("expected the ref of the registry, got %s", release.Pack.Ref())
+	}
+}
+
+const refOverridePackfile = `
+registries:
+  - name: myorg
+    url: github.com/myorg/packs
+    ref: v1.0.0
+environments:
+  staging:
+    ref: v1.2.0
+  production: {}
+releases:
+  - name: app
+    pack: registry://myorg/app
+  - name: api
+    pack: registry://myorg/api
+    ref: "{{ .Environment.Name }}"
+  - name: web
+    pack: registry://myorg/web@v1.3.0
+    ref: ignored
+`
+
+func TestCompileRefOverrides(t *testing.T) {
+	runner := nomadpacktest.New()
+	nomadPackFile, err := compile(t, refOverridePackfile, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"staging/app":    "v1.2.0",
+		"production/app": "v1.0.0",
+		"staging/api":    "staging",
+		"production/api": "production",
+		"staging/web":    "v1.3.0",
+		"production/web": "v1.3.0",
+	}
+	for _, release := range nomadPackFile.releases {
+		if ref := release.Pack.Ref(); ref != expected[release.label()] {
+			t.Errorf("release %s: expected ref %s, got %s", release.label(), expected[release.label()], ref)
+		}
+	}
+
+	_, err = nomadPackFile.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := []string{}
+	for _, call := range runner.CallsTo(nomadpacktest.OperationAddRegistry) {
+		added = append(added, call.Registry.Name+"@"+*call.Registry.Ref)
+	}
+	slices.Sort(added)
+	expectedAdded := []string{"myorg@production", "myorg@staging", "myorg@v1.0.0", "myorg@v1.2.0", "myorg@v1.3.0"}
+	if !slices.Equal(added, expectedAdded) {
+		t.Errorf("expected every registry ref to be added once, got %v", added)
+	}
+	for _, plan := range runner.CallsTo(nomadpacktest.OperationPlan) {
+		if plan.Invocation.Pack.Ref != expected[plan.Invocation.Label] {
+			t.Errorf("expected %s to be planned with --ref %s, got %s", plan.Invocation.Label, expected[plan.Invocation.Label], plan.Invocation.Pack.Ref)
+		}
+	}
+}
+
+func TestCompileNestedAndGitPacks(t *testing.T) {
+	runner := nomadpacktest.New()
+	nomadPackFile, err := compile(t, `
+registries:
+  - name: myorg
+    url: github.com/myorg/packs
+environments:
+  staging: {}
+releases:
+  - name: api
+    pack: registry://myorg/services/api
+  - name: web
+    pack: git::https://github.com/other/packs//web?ref=v2.0.0
+`, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nomadPackFile.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	packs := map[string]nomadpack.Pack{}
+	for _, plan := range runner.CallsTo(nomadpacktest.OperationPlan) {
+		packs[plan.Invocation.Label] = plan.Invocation.Pack
+	}
+	if api := packs["staging/api"]; api.Name != "services/api" || api.Registry != "myorg" {
+		t.Errorf("unexpected pack of api %+v", api)
+	}
+	if web := packs["staging/web"]; web.Name != "web" || web.Registry != "github.com-other-packs" || web.Ref != "v2.0.0" {
+		t.Errorf("unexpected pack of web %+v", web)
+	}
+
+	var added []nomadpack.Registry
+	for _, call := range runner.CallsTo(nomadpacktest.OperationAddRegistry) {
+		added = append(added, call.Registry)
+	}
+	if len(added) != 2 || added[1].URL != "git::https://github.com/other/packs" {
+		t.Errorf("expected the git repository to be added as a registry, got %+v", added)
+	}
+}
+
+func TestCompileValidatesLocalPacks(t *testing.T) {
+	cases := map[string]func(dir string){
+		"not found": func(dir string) {},
+		"metadata.hcl not found": func(dir string) {
+			os.MkdirAll(filepath.Join(dir, "packs", "app", "templates"), 0755)
+		},
+		"templates directory not found": func(dir string) {
+			os.MkdirAll(filepath.Join(dir, "packs", "app"), 0755)
+			os.WriteFile(filepath.Join(dir, "packs", "app", "metadata.hcl"), nil, 0644)
+		},
+	}
+	for expected, setup := range cases {
+		t.Run(expected, func(t *testing.T) {
+			dir := t.TempDir()
+			setup(dir)
+			config := configpkg.Config{
+				Path:         filepath.Join(dir, "packfile.yaml"),
+				Environments: map[string]configpkg.ReleaseConfig{"staging": {}},
+				Releases:     []configpkg.ReleaseConfig{{Name: "app", Pack: "./packs/app"}},
+			}
+			err := New(config, nomadpacktest.New(), test.GetLogger(t)).Compile()
+			if err == nil || !strings.Contains(err.Error(), "release app: pack ./packs/app") || !strings.Contains(err.Error(), expected) {
+				t.Errorf("expected a %q error, got %v", expected, err)
+			}
+		})
+	}
+}
+
+func TestCompileResolvesLocalPacksAgainstTheirFile(t *testing.T) {
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, "team-a"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "packfile.yaml"), []byte("environments:\n  staging: {}\n"), 0644)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "team-a", "releases.packfile.yaml"), []byte("releases:\n  - name: app\n    pack: ./packs/app\n"), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePack(t, filepath.Join(dir, "team-a", "packs", "app"))
+
+	config, err := configpkg.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Path = dir
+	runner := nomadpacktest.New()
+	nomadPackFile := New(config, runner, test.GetLogger(t))
+	err = nomadPackFile.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nomadPackFile.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := runner.CallsTo(nomadpacktest.OperationPlan)[0]
+	if plan.Invocation.Pack.Name != "./team-a/packs/app" || plan.Invocation.WorkDir != dir {
+		t.Errorf("expected nomad-pack to run in %s with ./team-a/packs/app, got %s in %s", dir, plan.Invocation.Pack.Name, plan.Invocation.WorkDir)
+	}
+}
+
+func TestCompileResolvesPathsAgainstTheirFile(t *testing.T) {
+	secretstest.Install(t)
+	dir := t.TempDir()
+	files := map[string]string{
+		"environments/staging.packfile.yaml": "environments:\n  staging:\n    environment-files: [staging.env]\n    secrets: [staging.hcl.age]\n    nomad-cacert: ca.pem\n",
+		"environments/staging.env":           "REGION=eu\n",
+		"team-a/releases.packfile.yaml":      "releases:\n  - name: app\n    pack: ./packs/app\n    var-files: [app.hcl]\n    environment-files: [app.env]\n    nomad-client-cert: client.pem\n    vars:\n      region: '{{ .Env.REGION }}'\n      team: '{{ .Env.TEAM }}'\n",
+		"team-a/app.env":                     "TEAM=a\n",
+		"team-a/app.hcl":                     "count = 1\n",
+	}
+	for name, content := range files {
+		err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	secretstest.Encrypt(t, filepath.Join(dir, "environments", "staging.hcl.age"), "image = \"nginx:1.27\"\n")
+	writePack(t, filepath.Join(dir, "team-a", "packs", "app"))
+
+	config, err := configpkg.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Path = dir
+	runner := nomadpacktest.New()
+	nomadPackFile := New(config, runner, test.GetLogger(t))
+	err = nomadPackFile.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := nomadPackFile.Release("staging", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.Vars["region"] != "eu" || app.Vars["team"] != "a" {
+		t.Errorf("expected the environment files next to their packfile, got %v", app.Vars)
+	}
+	if !slices.Equal(app.VarFiles, []string{filepath.Join("team-a", "app.hcl")}) {
+		t.Errorf("expected the var file next to the release, relative to %s, got %v", dir, app.VarFiles)
+	}
+	if app.Connection.CACert != filepath.Join(dir, "environments", "ca.pem") || app.Connection.ClientCert != filepath.Join(dir, "team-a", "client.pem") {
+		t.Errorf("expected the certificates next to the file that set them, got %+v", app.Connection)
+	}
+	if len(app.secrets) != 1 || string(app.secrets[0].Content) != "image = \"nginx:1.27\"\n" {
+		t.Errorf("expected the secrets next to the environment, got %v", app.secrets)
+	}
+}
+
+func TestCompileRefOfLocalPack(t *testing.T) {
+	_, err := compile(t, `
+environments:
+  staging:
+    ref: v1.0.0
+releases:
+  - name: app
+    pack: ./packs/app
+`, nomadpacktest.New())
+	if err != nil {
+		t.Fatalf("expected the ref of the environment to only apply to registry packs: %v", err)
+	}
+
+	_, err = compile(t, `
+environments:
+  staging: {}
+releases:
+  - name: app
+    pack: ./packs/app
+    ref: v1.0.0
+`, nomadpacktest.New())
+	if err == nil || !strings.Contains(err.Error(), "ref can only be set for packs of a registry") {
+		t.Errorf("expected an error for the ref of a local pack, got %v", err)
+	}
+}
+
+func TestLockedRefOverrides(t *testing.T) {
+	dir := t.TempDir()
+	lockFile := lock.Lock{Registries: []lock.Registry{
+		{Name: "myorg", URL: "github.com/myorg/packs", Ref: "v1.0.0", Commit: lockedCommit},
+		{Name: "myorg", URL: "github.com/myorg/packs", Ref: "v1.3.0", Commit: strings.Repeat("f", 40)},
+	}}
+	err := lockFile.Write(filepath.Join(dir, lock.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packfile := `
+registries:
+  - name: myorg
+    url: github.com/myorg/packs
+    ref: v1.0.0
+environments:
+  staging: {}
+releases:
+  - name: app
+    pack: registry://myorg/app
+  - name: web
+    pack: registry://myorg/web@v1.3.0
+`
+	nomadPackFile, err := compileDir(t, dir, packfile, nomadpacktest.New(), func(config *configpkg.Config) { config.Frozen = true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	web, _ := nomadPackFile.Release("staging", "web")
+	if web.Pack.Ref() != strings.Repeat("f", 40) {
+		t.Errorf("expected the locked commit of v1.3.0, got %s", web.Pack.Ref())
+	}
+	app, _ := nomadPackFile.Release("staging", "app")
+	if app.Pack.Ref() != lockedCommit {
+		t.Errorf("expected the locked commit of v1.0.0, got %s", app.Pack.Ref())
+	}
+
+	_, err = compileDir(t, dir, strings.Replace(packfile, "@v1.3.0", "@v1.4.0", 1), nomadpacktest.New(), func(config *configpkg.Config) { config.Frozen = true })
+	if err == nil || !strings.Contains(err.Error(), "myorg@v1.4.0") {
+		t.Errorf("expected an unlocked override to make the lock file stale, got %v", err)
+	}
+}
+
+func TestCompileWaitPrecedence(t *testing.T) {
+	yes, no := true, false
+	config := configpkg.Config{Wait: true, WaitTimeout: time.Minute}
+
+	wait, timeout, err := compileWait(config, configpkg.ReleaseConfig{Wait: &no}, configpkg.ReleaseConfig{Wait: &yes, WaitTimeout: "10s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wait || timeout != 10*time.Second {
+		t.Errorf("expected the release settings, got %v %s", wait, timeout)
+	}
+
+	wait, timeout, err = compileWait(config, configpkg.ReleaseConfig{Wait: &no}, configpkg.ReleaseConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait || timeout != time.Minute {
+		t.Errorf("expected the environment and flag settings, got %v %s", wait, timeout)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	server := nomadtest.NewServer(t)
+	runner := nomadpacktest.New()
+	dir := t.TempDir()
+	packfile := fmt.Sprintf(`
+registries:
+  - name: myorg
+    url: github.com/myorg/packs
+    ref: v1.0.0
+environments:
+  staging:
+    nomad-addr: %[1]s
+  production:
+    nomad-addr: %[1]s
+releases:
+  - name: app
+    pack: registry://myorg/app
+    var-files:
+      - app.hcl
+    vars:
+      image: "{{ .Env.IMAGE }}"
+      password: "{{ .Env.DB_PASSWORD }}"
+    sensitive-vars:
+      - password
+`, server.URL)
+	run := func(image, password, varFile string) *NomadPackFile {
+		t.Helper()
+		t.Setenv("IMAGE", image)
+		t.Setenv("DB_PASSWORD", password)
+		err := os.WriteFile(filepath.Join(dir, "app.hcl"), []byte(varFile), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nomadPackFile, err := compileDir(t, dir, packfile, runner, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = nomadPackFile.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return nomadPackFile
+	}
+
+	// Both environments share the cluster: revisions 1 (production) and 2 (staging) run nginx:1.25,
+	// 3 and 4 nginx:1.27.
+	run("nginx:1.25", "first-s3cr3t", "count = 1")
+	nomadPackFile := run("nginx:1.27", "second-s3cr3t", "count = 2")
+	if vars := server.Variable("nomad-packfile/app/2").Items["vars"]; strings.Contains(vars, "first-s3cr3t") || !strings.Contains(vars, "nginx:1.25") {
+		t.Errorf("expected the sensitive var not to be recorded, got %s", vars)
+	}
+
+	_, err := nomadPackFile.Rollback("staging", "app", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := runner.CallsTo(nomadpacktest.OperationRun)
+	rollback := runs[len(runs)-1]
+	if rollback.Invocation.Label != "staging/app" || rollback.Invocation.Vars["image"] != "nginx:1.25" || rollback.Invocation.Vars["password"] != "second-s3cr3t" {
+		t.Errorf("expected the recorded vars and the sensitive ones of the packfile, got %+v", rollback.Invocation)
+	}
+	for path, content := range rollback.VarFiles {
+		if content != "count = 1" {
+			t.Errorf("expected the recorded var file, got %s: %q", path, content)
+		}
+	}
+	if rollback.Invocation.Pack != (nomadpack.Pack{Name: "app", Registry: "myorg", Ref: "v1.0.0"}) {
+		t.Errorf("expected the recorded pack, got %+v", rollback.Invocation.Pack)
+	}
+
+	staging, err := nomadPackFile.Release("staging", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := staging.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	revisions := []int{}
+	for _, record := range records {
+		revisions = append(revisions, record.Revision)
+	}
+	if !slices.Equal(revisions, []int{2, 4, 5}) || records[2].RollbackOf != 2 {
+		t.Errorf("expected the revisions of staging, the last one rolling back to 2, got %+v", records)
+	}
+
+	_, err = nomadPackFile.Rollback("staging", "app", 1)
+	if err == nil || !strings.Contains(err.Error(), "revision 1 of release app was run in environment production, not in staging") {
+		t.Errorf("expected the revision of another environment to be rejected, got %v", err)
+	}
+}
+
+func TestRollbackRedactsTheRecordedVarsWhichAreNowSensitive(t *testing.T) {
+	server := nomadtest.NewServer(t)
+	store, err := history.New(nomadpack.Connection{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Add(history.Record{Release: "app", Environment: "staging", PackName: "app", Vars: map[string]string{"password": "recorded-s3cr3t", "legacy": "value"}, SensitiveVars: []string{"token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packfile := fmt.Sprintf(`
+environments:
+  staging:
+    nomad-addr: %s
+releases:
+  - name: app
+    pack: ./packs/app
+    vars:
+      password: current-s3cr3t
+    sensitive-vars:
+      - password
+`, server.URL)
+	nomadPackFile, err := compile(t, packfile, nomadpacktest.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = nomadPackFile.Rollback("staging", "app", 1)
+	if err == nil || !strings.Contains(err.Error(), "needs the sensitive var token") {
+		t.Errorf("expected the missing sensitive var to fail the rollback, got %v", err)
+	}
+	if redact.String("recorded-s3cr3t value") != redact.Mask+" value" {
+		t.Errorf("expected the recorded value of the sensitive var to be redacted, got %q", redact.String("recorded-s3cr3t value"))
+	}
+}
+
+// compile writes packfile and varFiles (empty) to a temporary directory and compiles it.
+func compile(t *testing.T, packfile string, runner nomadpack.Runner, varFiles ...string) (*NomadPackFile, error) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, varFile := range varFiles {
+		err := os.WriteFile(filepath.Join(dir, varFile), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return compileDir(t, dir, packfile, runner, nil)
+}
+
+// writePack writes an empty pack to dir.
+func writePack(t *testing.T, dir string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Join(dir, "templates"), 0755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "metadata.hcl"), nil, 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// compileDir writes packfile and its local packs to dir and compiles it, after calling configure, if not nil, with its config.
+func compileDir(t *testing.T, dir, packfile string, runner nomadpack.Runner, configure func(config *configpkg.Config)) (*NomadPackFile, error) {
+	t.Helper()
+	pterm.DisableOutput()
+
+	path := filepath.Join(dir, "packfile.yaml")
+	err := os.WriteFile(path, []byte(packfile), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := configpkg.Config{}
+	err = yaml.Unmarshal([]byte(packfile), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Path = path
+	for _, release := range config.Releases {
+		reference, err := ParsePackReference(release.Pack)
+		if err == nil && reference.Kind == PackReferenceLocal && !filepath.IsAbs(reference.Path) {
+			writePack(t, filepath.Join(dir, reference.Path))
+		}
+	}
+	if configure != nil {
+		configure(&config)
+	}
+
+	nomadPackFile := New(config, runner, test.GetLogger(t))
+	return nomadPackFile, nomadPackFile.Compile()
+}
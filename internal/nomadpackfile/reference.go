@@ -0,0 +1,244 @@
+package nomadpackfile
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	configpkg "github.com/magec/nomad-packfile/internal/config"
+)
+
+// Kinds of pack references.
+const (
+	// PackReferenceRegistry is a pack of a registry of the packfile: registry://<registry>/<path>[@<ref>].
+	PackReferenceRegistry = "registry"
+	// PackReferenceLocal is a pack in a local directory: a plain path or file://<path>.
+	PackReferenceLocal = "local"
+	// PackReferenceGit is a pack of a git repository not declared in the registries section:
+	// git::<url>//<path>, or a url ending in .git followed by //<path>. The ref is given with
+	// ?ref=<ref> or @<ref> after the path.
+	PackReferenceGit = "git"
+)
+
+// PackReference is the parsed pack of a release.
+type PackReference struct {
+	Kind string
+	// Registry is the name of the registry, for git references it is derived from URL.
+	Registry string
+	// URL is the url of the git repository of git references.
+	URL string
+	// Path is the path of the pack: in the registry or repository, or the local directory.
+	Path string
+	// Ref is the git ref of the registry or repository, empty when not given.
+	Ref string
+}
+
+// String returns the reference in its canonical form.
+func (reference PackReference) String() string {
+	s := reference.Path
+	switch reference.Kind {
+	case PackReferenceRegistry:
+		s = "registry://" + reference.Registry + "/" + reference.Path
+	case PackReferenceGit:
+		s = "git::" + reference.URL + "//" + reference.Path
+	}
+	if reference.Ref != "" {
+		s += "@" + reference.Ref
+	}
+	return s
+}
+
+var registryNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ParsePackReference parses the pack of a release, see the PackReference kinds for the grammar.
+func ParsePackReference(reference string) (PackReference, error) {
+	invalid := func(format string, args ...any) (PackReference, error) {
+		return PackReference{}, fmt.Errorf("invalid pack %q: %s", reference, fmt.Sprintf(format, args...))
+	}
+
+	switch {
+	case reference == "":
+		return invalid("it is empty")
+
+	case strings.HasPrefix(reference, "registry://"):
+		rest, ref, err := cutRef(strings.TrimPrefix(reference, "registry://"))
+		if err != nil {
+			return invalid("%v", err)
+		}
+		registry, packPath, found := strings.Cut(rest, "/")
+		if registry == "" {
+			return invalid("the registry name is missing, expected registry://<registry>/<pack>")
+		}
+		if !registryNamePattern.MatchString(registry) {
+			return invalid("%q is not a valid registry name", registry)
+		}
+		if !found || packPath == "" {
+			return invalid("the pack path is missing, expected registry://%s/<pack>", registry)
+		}
+		err = validatePackPath(packPath)
+		if err != nil {
+			return invalid("%v", err)
+		}
+		return PackReference{Kind: PackReferenceRegistry, Registry: registry, Path: packPath, Ref: ref}, nil
+
+	case strings.HasPrefix(reference, "file://"):
+		packPath := strings.TrimPrefix(reference, "file://")
+		if packPath == "" {
+			return invalid("the path is missing, expected file://<path>")
+		}
+		return PackReference{Kind: PackReferenceLocal, Path: packPath}, nil
+
+	case isGitURL(reference):
+		return parseGitReference(reference)
+
+	case strings.Contains(reference, "://"):
+		scheme, _, _ := strings.Cut(reference, "://")
+		return invalid("unknown scheme %s, expected registry://, file://, git:: or a path", scheme)
+	}
+
+	return PackReference{Kind: PackReferenceLocal, Path: reference}, nil
+}
+
+// isGitURL returns whether reference is a git url: git::<url>, a scp like git@host:repo or a url
+// whose repository ends in .git.
+func isGitURL(reference string) bool {
+	if strings.HasPrefix(reference, "git::") || strings.HasPrefix(reference, "git@") {
+		return true
+	}
+	repository, _, _ := strings.Cut(stripScheme(reference), "//")
+	return strings.Contains(reference, "://") && strings.HasSuffix(repository, ".git")
+}
+
+func parseGitReference(reference string) (PackReference, error) {
+	invalid := func(format string, args ...any) (PackReference, error) {
+		return PackReference{}, fmt.Errorf("invalid pack %q: %s", reference, fmt.Sprintf(format, args...))
+	}
+
+	rest := strings.TrimPrefix(reference, "git::")
+	var ref string
+	if base, query, found := strings.Cut(rest, "?"); found {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return invalid("%v", err)
+		}
+		ref = values.Get("ref")
+		rest = base
+	}
+
+	// The path of the pack follows the repository url after //, the one of the scheme aside.
+	scheme := ""
+	if i := strings.Index(rest, "://"); i != -1 {
+		scheme, rest = rest[:i+3], rest[i+3:]
+	}
+	repository, packPath, found := strings.Cut(rest, "//")
+	if !found || packPath == "" {
+		return invalid("the pack path is missing, expected <url>//<pack>")
+	}
+	packPath, pathRef, err := cutRef(packPath)
+	if err != nil {
+		return invalid("%v", err)
+	}
+	if pathRef != "" {
+		if ref != "" {
+			return invalid("the ref is given twice")
+		}
+		ref = pathRef
+	}
+	err = validatePackPath(packPath)
+	if err != nil {
+		return invalid("%v", err)
+	}
+	if repository == "" {
+		return invalid("the repository url is missing")
+	}
+
+	gitURL := scheme + repository
+	return PackReference{Kind: PackReferenceGit, Registry: registryName(gitURL), URL: gitURL, Path: packPath, Ref: ref}, nil
+}
+
+// cutRef splits <path>@<ref>. The ref may contain slashes, e.g. feature/new-app.
+func cutRef(s string) (string, string, error) {
+	packPath, ref, found := strings.Cut(s, "@")
+	if found && ref == "" {
+		return "", "", fmt.Errorf("the ref after @ is empty")
+	}
+	return packPath, ref, nil
+}
+
+// validatePackPath checks the path of a pack in a registry or repository, which must stay inside of it.
+func validatePackPath(packPath string) error {
+	if strings.HasPrefix(packPath, "/") {
+		return fmt.Errorf("the pack path %s must be relative", packPath)
+	}
+	for _, segment := range strings.Split(packPath, "/") {
+		switch segment {
+		case "":
+			return fmt.Errorf("the pack path %s has an empty segment", packPath)
+		case ".", "..":
+			return fmt.Errorf("the pack path %s cannot contain %s", packPath, segment)
+		}
+	}
+	return nil
+}
+
+func stripScheme(s string) string {
+	if _, rest, found := strings.Cut(s, "://"); found {
+		return rest
+	}
+	return s
+}
+
+var registryNameInvalid = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// registryName returns the name of the registry nomad-pack caches a git repository as, derived
+// from its url, e.g. github.com-myorg-packs for https://github.com/myorg/packs.git.
+func registryName(gitURL string) string {
+	name := stripScheme(gitURL)
+	if user, host, found := strings.Cut(name, "@"); found && !strings.Contains(user, "/") {
+		name = host
+	}
+	name = strings.TrimSuffix(path.Clean(strings.ReplaceAll(name, ":", "/")), ".git")
+	return strings.Trim(registryNameInvalid.ReplaceAllString(name, "-"), "-.")
+}
+
+// resolveLocalPack resolves the path of a local pack against the directory of the file that
+// declared the release (workDir when unknown) and checks that it looks like a pack: a directory
+// with a metadata.hcl file and a templates directory. It returns the path to pass to nomad-pack,
+// which runs in workDir.
+func resolveLocalPack(packPath string, release configpkg.ReleaseConfig, workDir string) (string, error) {
+	resolved := packPath
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(release.Dir(workDir), resolved)
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", fmt.Errorf("pack %s not found at %s", packPath, resolved)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("pack %s: %s is not a directory", packPath, resolved)
+	}
+	if info, err := os.Stat(filepath.Join(resolved, "metadata.hcl")); err != nil || info.IsDir() {
+		return "", fmt.Errorf("pack %s: %s is not a pack, metadata.hcl not found", packPath, resolved)
+	}
+	if info, err := os.Stat(filepath.Join(resolved, "templates")); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("pack %s: %s is not a pack, templates directory not found", packPath, resolved)
+	}
+
+	if filepath.IsAbs(packPath) {
+		return resolved, nil
+	}
+	relative, err := filepath.Rel(workDir, resolved)
+	if err != nil {
+		return resolved, nil
+	}
+	// nomad-pack takes a name without a directory as a pack of its default registry.
+	if !strings.HasPrefix(relative, "..") {
+		relative = "." + string(filepath.Separator) + relative
+	}
+	return relative, nil
+}
@@ -0,0 +1,78 @@
+package nomadpackfile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePackReference(t *testing.T) {
+	cases := map[string]PackReference{
+		"./packs/app":                         {Kind: PackReferenceLocal, Path: "./packs/app"},
+		"packs/app":                           {Kind: PackReferenceLocal, Path: "packs/app"},
+		"file:///srv/packs/app":               {Kind: PackReferenceLocal, Path: "/srv/packs/app"},
+		"file://packs/app":                    {Kind: PackReferenceLocal, Path: "packs/app"},
+		"registry://myorg/app":                {Kind: PackReferenceRegistry, Registry: "myorg", Path: "app"},
+		"registry://myorg/services/api/app":   {Kind: PackReferenceRegistry, Registry: "myorg", Path: "services/api/app"},
+		"registry://myorg/app@v1.3.0":         {Kind: PackReferenceRegistry, Registry: "myorg", Path: "app", Ref: "v1.3.0"},
+		"registry://myorg/app@feature/new-ui": {Kind: PackReferenceRegistry, Registry: "myorg", Path: "app", Ref: "feature/new-ui"},
+		"git::https://github.com/myorg/packs//packs/app": {
+			Kind: PackReferenceGit, Registry: "github.com-myorg-packs", URL: "https://github.com/myorg/packs", Path: "packs/app",
+		},
+		"https://github.com/myorg/packs.git//app?ref=v2": {
+			Kind: PackReferenceGit, Registry: "github.com-myorg-packs", URL: "https://github.com/myorg/packs.git", Path: "app", Ref: "v2",
+		},
+		"git@github.com:myorg/packs.git//app@v2": {
+			Kind: PackReferenceGit, Registry: "github.com-myorg-packs", URL: "git@github.com:myorg/packs.git", Path: "app", Ref: "v2",
+		},
+	}
+	for input, expected := range cases {
+		reference, err := ParsePackReference(input)
+		if err != nil {
+			t.Errorf("ParsePackReference(%q): %v", input, err)
+			continue
+		}
+		if reference != expected {
+			t.Errorf("ParsePackReference(%q) = %+v, expected %+v", input, reference, expected)
+		}
+	}
+}
+
+func TestParsePackReferenceErrors(t *testing.T) {
+	cases := map[string]string{
+		"":                                    "it is empty",
+		"registry://":                         "the registry name is missing",
+		"registry:///app":                     "the registry name is missing",
+		"registry://myorg":                    "the pack path is missing, expected registry://myorg/<pack>",
+		"registry://myorg/":                   "the pack path is missing",
+		"registry://my org/app":               `"my org" is not a valid registry name`,
+		"registry://myorg/app@":               "the ref after @ is empty",
+		"registry://myorg/services//app":      "has an empty segment",
+		"registry://myorg/../app":             "cannot contain ..",
+		"file://":                             "the path is missing",
+		"s3://bucket/packs/app":               "unknown scheme s3",
+		"git::https://github.com/myorg/packs": "the pack path is missing, expected <url>//<pack>",
+		"git::https://github.com/myorg/packs//app@v1?ref=v2": "the ref is given twice",
+	}
+	for input, expected := range cases {
+		_, err := ParsePackReference(input)
+		if err == nil {
+			t.Errorf("ParsePackReference(%q): expected an error", input)
+			continue
+		}
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("ParsePackReference(%q): expected the error to contain %q, got %q", input, expected, err)
+		}
+	}
+}
+
+func TestPackReferenceString(t *testing.T) {
+	for _, input := range []string{"./packs/app", "registry://myorg/services/app@v1.3.0", "git::https://github.com/myorg/packs//app@v2"} {
+		reference, err := ParsePackReference(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reference.String() != input {
+			t.Errorf("expected %s, got %s", input, reference.String())
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Package redact keeps track of secret values (Nomad tokens, sensitive vars...) and masks them
+// in everything nomad-packfile outputs: terminal, logs and reports.
+package redact
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Mask is what secrets are replaced with.
+const Mask = "<redacted>"
+
+var (
+	mu       sync.RWMutex
+	secrets  = map[string]struct{}{}
+	replacer = strings.NewReplacer()
+)
+
+// Add registers values that must never be shown. Empty values are ignored.
+func Add(values ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			secrets[value] = struct{}{}
+		}
+	}
+
+	// Longer secrets go first so a secret containing another one is fully masked.
+	sorted := make([]string, 0, len(secrets))
+	for secret := range secrets {
+		sorted = append(sorted, secret)
+	}
+	slices.SortFunc(sorted, func(a, b string) int { return len(b) - len(a) })
+
+	pairs := make([]string, 0, len(sorted)*2)
+	for _, secret := range sorted {
+		pairs = append(pairs, secret, Mask)
+	}
+	replacer = strings.NewReplacer(pairs...)
+}
+
+// String returns s with every registered secret masked.
+func String(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return replacer.Replace(s)
+}
+
+// Contains returns whether s contains a registered secret.
+func Contains(s string) bool {
+	return String(s) != s
+}
+
+// Strings returns a copy of values with every registered secret masked.
+func Strings(values []string) []string {
+	redacted := make([]string, len(values))
+	for i, value := range values {
+		redacted[i] = String(value)
+	}
+	return redacted
+}
+
+type writer struct {
+	w io.Writer
+}
+
+// Writer wraps w so that every registered secret is masked before reaching it.
+func Writer(w io.Writer) io.Writer {
+	return writer{w: w}
+}
+
+func (w writer) Write(p []byte) (int, error) {
+	_, err := io.WriteString(w.w, String(string(p)))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
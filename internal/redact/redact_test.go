@@ -0,0 +1,36 @@
+package redact
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	Add("s3cr3t", "", "s3cr3t-token")
+
+	redacted := String("nomad-pack run -var password=s3cr3t -var token=s3cr3t-token")
+	expected := "nomad-pack run -var password=" + Mask + " -var token=" + Mask
+	if redacted != expected {
+		t.Errorf("expected %q, got %q", expected, redacted)
+	}
+
+	if String("nothing to hide") != "nothing to hide" {
+		t.Errorf("expected strings without secrets to be untouched")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	Add("hunter2")
+
+	var b strings.Builder
+	n, err := Writer(&b).Write([]byte("password: hunter2\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != len("password: hunter2\n") {
+		t.Errorf("expected the length of the original input to be returned, got %d", n)
+	}
+	if b.String() != "password: "+Mask+"\n" {
+		t.Errorf("unexpected output %q", b.String())
+	}
+}
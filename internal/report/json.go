@@ -0,0 +1,69 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/magec/nomad-packfile/internal/nomadpack"
+	"github.com/magec/nomad-packfile/internal/nomadpackfile"
+)
+
+type jsonSummary struct {
+	Changed   int `json:"changed"`
+	Unchanged int `json:"unchanged"`
+	Failed    int `json:"failed"`
+}
+
+type jsonRelease struct {
+	Release         string                       `json:"release"`
+	Status          string                       `json:"status"`
+	ExitCode        int                          `json:"exit_code"`
+	DurationSeconds float64                      `json:"duration_seconds"`
+	Output          string                       `json:"output"`
+	Stderr          string                       `json:"stderr,omitempty"`
+	Error           string                       `json:"error,omitempty"`
+	Deployments     []nomadpack.DeploymentResult `json:"deployments,omitempty"`
+}
+
+type jsonReport struct {
+	// Drift is set when any release would change the cluster.
+	Drift        bool                     `json:"drift"`
+	Summary      jsonSummary              `json:"summary"`
+	Environments map[string][]jsonRelease `json:"environments"`
+}
+
+// WriteJSON writes a machine readable report with the status (changed, unchanged or failed) and the
+// output of every release, grouped by environment.
+func WriteJSON(w io.Writer, results []nomadpackfile.ReleaseResult) error {
+	s := summarize(results)
+	report := jsonReport{
+		Drift:        s.changed > 0,
+		Summary:      jsonSummary{Changed: s.changed, Unchanged: s.unchanged, Failed: s.failed},
+		Environments: map[string][]jsonRelease{},
+	}
+
+	environments, byEnvironment := groupByEnvironment(results)
+	for _, environment := range environments {
+		releases := []jsonRelease{}
+		for _, result := range byEnvironment[environment] {
+			release := jsonRelease{
+				Release:         result.Release,
+				Status:          statusName(result),
+				ExitCode:        result.Result.ExitCode,
+				DurationSeconds: result.Result.Duration.Seconds(),
+				Output:          stripANSI(result.Result.Stdout),
+				Stderr:          stripANSI(result.Result.Stderr),
+				Deployments:     result.Result.Deployments,
+			}
+			if result.Failed() {
+				release.Error = result.Err.Error()
+			}
+			releases = append(releases, release)
+		}
+		report.Environments[environment] = releases
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
@@ -0,0 +1,46 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/magec/nomad-packfile/internal/nomadpack"
+	"github.com/magec/nomad-packfile/internal/nomadpackfile"
+)
+
+func TestWriteJSON(t *testing.T) {
+	results := []nomadpackfile.ReleaseResult{
+		{Environment: "staging", Release: "app", Result: nomadpack.Result{Stdout: "+/- Job: \"app\"", Changes: true, ExitCode: 2}},
+		{Environment: "production", Release: "app", Result: nomadpack.Result{ExitCode: 0}},
+		{Environment: "production", Release: "db", Result: nomadpack.Result{ExitCode: 255}, Err: errors.New("exit status 255")},
+	}
+
+	var b strings.Builder
+	err := WriteJSON(&b, results)
+	if err != nil {
+		t.Fatalf("failed to write report: %v", err)
+	}
+
+	var parsed jsonReport
+	err = json.Unmarshal([]byte(b.String()), &parsed)
+	if err != nil {
+		t.Fatalf("report is not valid JSON: %v", err)
+	}
+
+	if !parsed.Drift {
+		t.Errorf("expected drift to be detected")
+	}
+	if parsed.Summary != (jsonSummary{Changed: 1, Unchanged: 1, Failed: 1}) {
+		t.Errorf("unexpected summary %+v", parsed.Summary)
+	}
+	staging := parsed.Environments["staging"]
+	if len(staging) != 1 || staging[0].Status != "changed" || staging[0].Output != "+/- Job: \"app\"" {
+		t.Errorf("unexpected staging releases %+v", staging)
+	}
+	production := parsed.Environments["production"]
+	if len(production) != 2 || production[0].Status != "unchanged" || production[1].Status != "failed" || production[1].Error != "exit status 255" {
+		t.Errorf("unexpected production releases %+v", production)
+	}
+}
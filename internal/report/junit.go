@@ -0,0 +1,82 @@
+package report
+
+import (
+	"encoding/xml"
+	"io"
+
+	"github.com/magec/nomad-packfile/internal/nomadpackfile"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     float64          `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      float64         `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Content string `xml:",chardata"`
+}
+
+// WriteJUnit writes the results as a JUnit XML report. Every environment is a test suite
+// and every release in it a test case.
+func WriteJUnit(w io.Writer, results []nomadpackfile.ReleaseResult) error {
+	testSuites := junitTestSuites{}
+	environments, byEnvironment := groupByEnvironment(results)
+
+	for _, environment := range environments {
+		suite := junitTestSuite{Name: environment}
+		for _, result := range byEnvironment[environment] {
+			testCase := junitTestCase{
+				Name:      result.Release,
+				ClassName: environment,
+				Time:      result.Result.Duration.Seconds(),
+				SystemOut: stripANSI(result.Result.Stdout),
+				SystemErr: stripANSI(result.Result.Stderr),
+			}
+			if result.Failed() {
+				testCase.Failure = &junitFailure{Message: result.Err.Error(), Content: result.Result.Command}
+				suite.Failures++
+			}
+			suite.Tests++
+			suite.Time += testCase.Time
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+		testSuites.Tests += suite.Tests
+		testSuites.Failures += suite.Failures
+		testSuites.Time += suite.Time
+		testSuites.Suites = append(testSuites.Suites, suite)
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	err = encoder.Encode(testSuites)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,45 @@
+package report
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magec/nomad-packfile/internal/nomadpack"
+	"github.com/magec/nomad-packfile/internal/nomadpackfile"
+)
+
+func TestWriteJUnit(t *testing.T) {
+	results := []nomadpackfile.ReleaseResult{
+		{Environment: "staging", Release: "app", Result: nomadpack.Result{Stdout: "ok", Duration: 2 * time.Second}},
+		{Environment: "staging", Release: "db", Result: nomadpack.Result{Stderr: "boom", Duration: time.Second}, Err: errors.New("exit status 1")},
+	}
+
+	var b strings.Builder
+	err := WriteJUnit(&b, results)
+	if err != nil {
+		t.Fatalf("failed to write report: %v", err)
+	}
+
+	var parsed junitTestSuites
+	err = xml.Unmarshal([]byte(b.String()), &parsed)
+	if err != nil {
+		t.Fatalf("report is not valid XML: %v", err)
+	}
+
+	if parsed.Tests != 2 || parsed.Failures != 1 || len(parsed.Suites) != 1 {
+		t.Fatalf("unexpected totals: %+v", parsed)
+	}
+	suite := parsed.Suites[0]
+	if suite.Name != "staging" || suite.Time != 3 {
+		t.Errorf("unexpected suite: %+v", suite)
+	}
+	if suite.TestCases[0].Failure != nil || suite.TestCases[0].SystemOut != "ok" {
+		t.Errorf("unexpected test case: %+v", suite.TestCases[0])
+	}
+	if suite.TestCases[1].Failure == nil || suite.TestCases[1].Failure.Message != "exit status 1" || suite.TestCases[1].SystemErr != "boom" {
+		t.Errorf("unexpected test case: %+v", suite.TestCases[1])
+	}
+}
@@ -0,0 +1,104 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/magec/nomad-packfile/internal/nomadpackfile"
+)
+
+type summary struct {
+	changed, unchanged, failed int
+}
+
+func (s summary) String() string {
+	return fmt.Sprintf("%d changed, %d unchanged, %d failed", s.changed, s.unchanged, s.failed)
+}
+
+func summarize(results []nomadpackfile.ReleaseResult) (s summary) {
+	for _, result := range results {
+		switch {
+		case result.Failed():
+			s.failed++
+		case result.Result.Changes:
+			s.changed++
+		default:
+			s.unchanged++
+		}
+	}
+	return
+}
+
+// statusName returns whether the release failed, changed or was left unchanged.
+func statusName(result nomadpackfile.ReleaseResult) string {
+	switch {
+	case result.Failed():
+		return "failed"
+	case result.Result.Changes:
+		return "changed"
+	default:
+		return "unchanged"
+	}
+}
+
+var statusEmojis = map[string]string{
+	"failed":    ":x:",
+	"changed":   ":warning:",
+	"unchanged": ":white_check_mark:",
+}
+
+func status(result nomadpackfile.ReleaseResult) string {
+	name := statusName(result)
+	return statusEmojis[name] + " " + name
+}
+
+// WriteMarkdown writes a plan report suitable to be posted as a pull request comment. It contains
+// a collapsible section per environment and, inside it, one per release with the plan diff.
+func WriteMarkdown(w io.Writer, results []nomadpackfile.ReleaseResult) error {
+	var b strings.Builder
+	environments, byEnvironment := groupByEnvironment(results)
+
+	fmt.Fprintf(&b, "## nomad-packfile plan\n\n")
+	fmt.Fprintf(&b, "**Total**: %s\n\n", summarize(results))
+	if len(results) == 0 {
+		fmt.Fprintf(&b, "No releases were planned.\n")
+	}
+
+	for _, environment := range environments {
+		environmentResults := byEnvironment[environment]
+		fmt.Fprintf(&b, "<details>\n<summary><b>%s</b>: %s</summary>\n\n", environment, summarize(environmentResults))
+		fmt.Fprintf(&b, "| Release | Status | Duration |\n|---|---|---|\n")
+		for _, result := range environmentResults {
+			fmt.Fprintf(&b, "| %s | %s | %s |\n", result.Release, status(result), result.Result.Duration.Round(100*time.Millisecond))
+		}
+		fmt.Fprintf(&b, "\n")
+
+		for _, result := range environmentResults {
+			fmt.Fprintf(&b, "<details>\n<summary>%s: %s</summary>\n\n", result.Release, status(result))
+			if result.Failed() {
+				fmt.Fprintf(&b, "Error: `%s`\n\n", result.Err)
+				writeCodeBlock(&b, "", result.Result.Stderr)
+			}
+			writeCodeBlock(&b, "diff", result.Result.Stdout)
+			fmt.Fprintf(&b, "</details>\n\n")
+		}
+		fmt.Fprintf(&b, "</details>\n\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeCodeBlock(b *strings.Builder, language, content string) {
+	content = strings.TrimSpace(stripANSI(content))
+	if content == "" {
+		return
+	}
+	fence := "```"
+	for strings.Contains(content, fence) {
+		fence += "`"
+	}
+	fmt.Fprintf(b, "%s%s\n%s\n%s\n\n", fence, language, content, fence)
+}
@@ -0,0 +1,75 @@
+package report
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/magec/nomad-packfile/internal/nomadpack"
+	"github.com/magec/nomad-packfile/internal/nomadpackfile"
+	"github.com/magec/nomad-packfile/internal/redact"
+)
+
+func TestWriteMarkdown(t *testing.T) {
+	results := []nomadpackfile.ReleaseResult{
+		{Environment: "staging", Release: "app", Result: nomadpack.Result{Stdout: "+ Job: \"app\"", Changes: true}},
+		{Environment: "production", Release: "app", Result: nomadpack.Result{Stderr: "boom"}, Err: errors.New("exit status 255")},
+		{Environment: "staging", Release: "db", Result: nomadpack.Result{Stdout: "\x1b[1mJob: \"db\"\x1b[0m"}},
+	}
+
+	var b strings.Builder
+	err := WriteMarkdown(&b, results)
+	if err != nil {
+		t.Fatalf("failed to write report: %v", err)
+	}
+	report := b.String()
+
+	for _, expected := range []string{
+		"**Total**: 1 changed, 1 unchanged, 1 failed",
+		"<summary><b>staging</b>: 1 changed, 1 unchanged, 0 failed</summary>",
+		"<summary><b>production</b>: 0 changed, 0 unchanged, 1 failed</summary>",
+		"```diff\n+ Job: \"app\"\n```",
+		"Error: `exit status 255`",
+		"```diff\nJob: \"db\"\n```",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("expected report to contain %q, got:\n%s", expected, report)
+		}
+	}
+
+	if strings.Index(report, "production") > strings.Index(report, "staging") {
+		t.Errorf("expected environments to be sorted")
+	}
+}
+
+func TestWriteInvalidSpec(t *testing.T) {
+	for _, spec := range []string{"markdown", "markdown=", "pdf=report.pdf"} {
+		if err := Write(spec, nil); err == nil {
+			t.Errorf("expected an error for spec %q", spec)
+		}
+	}
+}
+
+func TestWriteRedactsSecrets(t *testing.T) {
+	redact.Add("topsecret")
+	results := []nomadpackfile.ReleaseResult{
+		{Environment: "staging", Release: "app", Result: nomadpack.Result{Command: "nomad-pack run -var password=topsecret", Stdout: "topsecret"}, Err: errors.New("failed with topsecret")},
+	}
+
+	for _, format := range []string{"markdown", "junit", "json"} {
+		path := filepath.Join(t.TempDir(), "report")
+		err := Write(format+"="+path, results)
+		if err != nil {
+			t.Fatalf("failed to write report: %v", err)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read report: %v", err)
+		}
+		if strings.Contains(string(content), "topsecret") {
+			t.Errorf("expected secrets to be redacted from %s report:\n%s", format, content)
+		}
+	}
+}
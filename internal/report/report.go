@@ -0,0 +1,79 @@
+package report
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/magec/nomad-packfile/internal/nomadpackfile"
+	"github.com/magec/nomad-packfile/internal/redact"
+)
+
+// writers maps every supported report format to the function generating it.
+var writers = map[string]func(w io.Writer, results []nomadpackfile.ReleaseResult) error{
+	"markdown": WriteMarkdown,
+	"junit":    WriteJUnit,
+	"json":     WriteJSON,
+}
+
+// Write generates the report described by spec. The spec has the form format=path, e.g. markdown=plan.md.
+func Write(spec string, results []nomadpackfile.ReleaseResult) error {
+	format, path, found := strings.Cut(spec, "=")
+	if !found || path == "" {
+		return fmt.Errorf("invalid report %q, expected format=path", spec)
+	}
+
+	writer, ok := writers[format]
+	if !ok {
+		return fmt.Errorf("unknown report format %q", format)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return writer(file, redactResults(results))
+}
+
+// redactResults returns a copy of results with every secret masked.
+func redactResults(results []nomadpackfile.ReleaseResult) []nomadpackfile.ReleaseResult {
+	redacted := make([]nomadpackfile.ReleaseResult, len(results))
+	for i, result := range results {
+		result.Result.Command = redact.String(result.Result.Command)
+		result.Result.Stdout = redact.String(result.Result.Stdout)
+		result.Result.Stderr = redact.String(result.Result.Stderr)
+		if result.Err != nil {
+			result.Err = errors.New(redact.String(result.Err.Error()))
+		}
+		redacted[i] = result
+	}
+	return redacted
+}
+
+// groupByEnvironment returns the environment names sorted and the results of each one in their original order.
+func groupByEnvironment(results []nomadpackfile.ReleaseResult) ([]string, map[string][]nomadpackfile.ReleaseResult) {
+	environments := []string{}
+	byEnvironment := map[string][]nomadpackfile.ReleaseResult{}
+	for _, result := range results {
+		if _, ok := byEnvironment[result.Environment]; !ok {
+			environments = append(environments, result.Environment)
+		}
+		byEnvironment[result.Environment] = append(byEnvironment[result.Environment], result)
+	}
+	slices.Sort(environments)
+
+	return environments, byEnvironment
+}
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]`)
+
+// stripANSI removes the terminal escape sequences nomad-pack uses to colorize its output.
+func stripANSI(s string) string {
+	return ansiEscape.ReplaceAllString(s, "")
+}
@@ -0,0 +1,255 @@
+// Package secrets decrypts the secrets files of a packfile, encrypted with SOPS, using the sops
+// binary, or with age, in process with the local age keys. Decrypted values are kept in memory and
+// registered in package redact, so they never show up in the output.
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/magec/nomad-packfile/internal/redact"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of the decrypted files.
+const (
+	// FormatYAML files are a map of values, exposed to templates.
+	FormatYAML = "yaml"
+	// FormatHCL files are nomad-pack var files.
+	FormatHCL = "hcl"
+)
+
+// AgeExtension is the extension of files encrypted with age, any other file is decrypted with sops.
+const AgeExtension = ".age"
+
+// File is a decrypted secrets file.
+type File struct {
+	// Name is the name of the file without AgeExtension, e.g. production.hcl for production.hcl.age.
+	Name   string
+	Format string
+	// Content is the plaintext of the file.
+	Content []byte
+	// Values are the values of a FormatYAML file.
+	Values map[string]any
+}
+
+// Read decrypts the secrets file at path, whose format is given by its extension once AgeExtension
+// is removed: .yaml, .yml or .hcl. The string values of the file are registered as secrets.
+func Read(path string) (File, error) {
+	file := File{Name: strings.TrimSuffix(filepath.Base(path), AgeExtension)}
+	switch filepath.Ext(file.Name) {
+	case ".yaml", ".yml":
+		file.Format = FormatYAML
+	case ".hcl":
+		file.Format = FormatHCL
+	default:
+		return File{}, fmt.Errorf("secrets file %s: unsupported format, expected .yaml, .yml or .hcl", path)
+	}
+
+	content, err := decrypt(path, file.Format)
+	if err != nil {
+		return File{}, fmt.Errorf("secrets file %s: %w", path, err)
+	}
+	file.Content = content
+
+	switch file.Format {
+	case FormatYAML:
+		file.Values = map[string]any{}
+		err = yaml.Unmarshal(content, &file.Values)
+		if err != nil {
+			// The error of the parser may quote the plaintext.
+			return File{}, fmt.Errorf("secrets file %s: the decrypted content is not a YAML map", path)
+		}
+		if file.Values == nil {
+			file.Values = map[string]any{}
+		}
+		addValues(file.Values)
+	case FormatHCL:
+		addHCLStrings(content)
+	}
+	return file, nil
+}
+
+func decrypt(path, format string) ([]byte, error) {
+	if !strings.HasSuffix(path, AgeExtension) {
+		return decryptSOPS(path, format)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	identities, err := ageIdentities()
+	if err != nil {
+		return nil, err
+	}
+	return decryptAge(content, identities)
+}
+
+// decryptAge decrypts content, binary or armored, encrypted with age.
+func decryptAge(content []byte, identities []age.Identity) ([]byte, error) {
+	var src io.Reader = bytes.NewReader(content)
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte(armor.Header)) {
+		src = armor.NewReader(bytes.NewReader(bytes.TrimSpace(content)))
+	}
+	plaintext, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt with age: %w", err)
+	}
+	return io.ReadAll(plaintext)
+}
+
+// ageIdentities returns the local age keys. Like sops, they are SOPS_AGE_KEY, or read from
+// SOPS_AGE_KEY_FILE or sops/age/keys.txt in the user configuration directory.
+func ageIdentities() ([]age.Identity, error) {
+	if keys := os.Getenv("SOPS_AGE_KEY"); keys != "" {
+		identities, err := age.ParseIdentities(strings.NewReader(keys))
+		if err != nil {
+			return nil, fmt.Errorf("could not read the age keys of SOPS_AGE_KEY: %w", err)
+		}
+		return identities, nil
+	}
+
+	path := os.Getenv("SOPS_AGE_KEY_FILE")
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("could not find the age keys, set SOPS_AGE_KEY_FILE: %w", err)
+		}
+		path = filepath.Join(dir, "sops", "age", "keys.txt")
+	}
+	keys, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the age keys: %w", err)
+	}
+	defer keys.Close()
+	identities, err := age.ParseIdentities(keys)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the age keys of %s: %w", path, err)
+	}
+	return identities, nil
+}
+
+// Merge returns the values of the YAML files, the ones of a file taking precedence over the ones
+// of the previous files.
+func Merge(files []File) map[string]any {
+	values := map[string]any{}
+	for _, file := range files {
+		for key, value := range file.Values {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// WriteVarFiles writes the FormatHCL files to a new temporary directory, only readable by the
+// current user, and returns their paths. remove deletes them, it must be called as soon as they
+// are not needed anymore.
+func WriteVarFiles(files []File) (paths []string, remove func(), err error) {
+	remove = func() {}
+	dir := ""
+	for i, file := range files {
+		if file.Format != FormatHCL {
+			continue
+		}
+		if dir == "" {
+			dir, err = os.MkdirTemp("", "nomad-packfile-secrets-")
+			if err != nil {
+				return nil, remove, err
+			}
+			remove = func() { os.RemoveAll(dir) }
+		}
+		// Files of different directories may have the same name.
+		path := filepath.Join(dir, fmt.Sprintf("%d-%s", i, file.Name))
+		err = os.WriteFile(path, file.Content, 0600)
+		if err != nil {
+			remove()
+			return nil, func() {}, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, remove, nil
+}
+
+// addValues registers the string values of a YAML document. Other scalars, like numbers or
+// booleans, are too likely to show up in the output for other reasons to be masked.
+func addValues(value any) {
+	switch value := value.(type) {
+	case map[string]any:
+		for _, v := range value {
+			addValues(v)
+		}
+	case []any:
+		for _, v := range value {
+			addValues(v)
+		}
+	case string:
+		addString(value)
+	}
+}
+
+var (
+	hclString       = regexp.MustCompile(`"(?:[^"\\]|\\.)*"`)
+	hclHeredocStart = regexp.MustCompile(`<<-?([A-Za-z_][A-Za-z0-9_]*)\s*$`)
+)
+
+// addHCLStrings registers the quoted strings and the heredocs of a var file.
+func addHCLStrings(content []byte) {
+	lines := strings.Split(string(content), "\n")
+	for i := 0; i < len(lines); i++ {
+		if match := hclHeredocStart.FindStringSubmatch(lines[i]); match != nil {
+			heredoc := []string{}
+			for i++; i < len(lines) && strings.TrimSpace(lines[i]) != match[1]; i++ {
+				heredoc = append(heredoc, lines[i])
+			}
+			addString(strings.Join(heredoc, "\n"))
+			continue
+		}
+		for _, quoted := range hclString.FindAllString(lines[i], -1) {
+			value, err := strconv.Unquote(quoted)
+			if err != nil {
+				value = quoted[1 : len(quoted)-1]
+			}
+			addString(value)
+		}
+	}
+}
+
+// minSecretLength is the length under which values, and lines of values, are not registered: short
+// ones, like a region, a user name or a closing brace, are too likely to show up in the output for
+// other reasons, e.g. in the name of a release.
+const minSecretLength = 6
+
+// addString registers value and, as output is redacted line by line, every line of it, when they
+// are secret-like.
+func addString(value string) {
+	if isSecretLike(value) {
+		redact.Add(value)
+	}
+	if !strings.Contains(value, "\n") {
+		return
+	}
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if isSecretLike(line) {
+			redact.Add(line)
+		}
+	}
+}
+
+// isSecretLike returns whether value is long enough and not only punctuation.
+func isSecretLike(value string) bool {
+	return len(strings.TrimSpace(value)) >= minSecretLength && strings.IndexFunc(value, isAlphanumeric) >= 0
+}
+
+func isAlphanumeric(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,212 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/magec/nomad-packfile/internal/redact"
+	"github.com/magec/nomad-packfile/internal/secrets/secretstest"
+)
+
+func TestReadYAML(t *testing.T) {
+	secretstest.Install(t)
+	path := filepath.Join(t.TempDir(), "production.yaml")
+	secretstest.Encrypt(t, path, "db:\n  password: yaml-s3cr3t\nregion: eu\nport: 5432\ncerts:\n  - |\n    line-one-s3cr3t\n    line-two-s3cr3t\n")
+
+	file, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Format != FormatYAML || file.Name != "production.yaml" {
+		t.Errorf("unexpected file %s of format %s", file.Name, file.Format)
+	}
+	db, _ := file.Values["db"].(map[string]any)
+	if db["password"] != "yaml-s3cr3t" || file.Values["port"] != 5432 {
+		t.Errorf("expected the decrypted values with their types, got %v", file.Values)
+	}
+	if !strings.HasPrefix(string(file.Content), "db:\n  password: yaml-s3cr3t\n") {
+		t.Errorf("expected the plaintext, got %q", file.Content)
+	}
+
+	redacted := redact.String("password=yaml-s3cr3t region=eu port=5432 cert=line-two-s3cr3t")
+	expected := "password=" + redact.Mask + " region=eu port=5432 cert=" + redact.Mask
+	if redacted != expected {
+		t.Errorf("expected %q, got %q", expected, redacted)
+	}
+}
+
+func TestReadHCL(t *testing.T) {
+	secretstest.Install(t)
+	path := filepath.Join(t.TempDir(), "production.hcl")
+	secretstest.Encrypt(t, path, "token = \"hcl-s3cr3t\"\nescaped = \"with \\\"quotes\\\"\"\nkey = <<EOF\n{\n  heredoc-s3cr3t\n}\nEOF\n")
+
+	file, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Format != FormatHCL || file.Values != nil {
+		t.Errorf("expected a var file, got %s %v", file.Format, file.Values)
+	}
+	if !strings.HasPrefix(string(file.Content), "token = ") {
+		t.Errorf("expected the plaintext, got %q", file.Content)
+	}
+
+	redacted := redact.String(`hcl-s3cr3t with "quotes" heredoc-s3cr3t EOF { }`)
+	expected := strings.Repeat(redact.Mask+" ", 3) + "EOF { }"
+	if redacted != expected {
+		t.Errorf("expected %q, got %q", expected, redacted)
+	}
+}
+
+func TestReadAge(t *testing.T) {
+	secretstest.Install(t)
+	path := filepath.Join(t.TempDir(), "production.hcl.age")
+	secretstest.Encrypt(t, path, "token = \"age-s3cr3t\"\n")
+
+	file, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Name != "production.hcl" || file.Format != FormatHCL {
+		t.Errorf("expected production.hcl in HCL, got %s in %s", file.Name, file.Format)
+	}
+	if string(file.Content) != "token = \"age-s3cr3t\"\n" {
+		t.Errorf("expected the plaintext, got %q", file.Content)
+	}
+
+	identity := secretstest.Install(t)
+	_, err = Read(path)
+	if err == nil || !strings.Contains(err.Error(), "could not decrypt with age: no identity matched any of the recipients") {
+		t.Errorf("expected the error of age, got %v", err)
+	}
+
+	t.Setenv("SOPS_AGE_KEY_FILE", filepath.Join(t.TempDir(), "missing"))
+	t.Setenv("SOPS_AGE_KEY", identity.String())
+	_, err = Read(path)
+	if err == nil || !strings.Contains(err.Error(), "no identity matched") {
+		t.Errorf("expected the keys of SOPS_AGE_KEY to take precedence, got %v", err)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	secretstest.Install(t)
+	dir := t.TempDir()
+
+	_, err := Read(filepath.Join(dir, "production.json"))
+	if err == nil || !strings.Contains(err.Error(), "unsupported format") {
+		t.Errorf("expected an unsupported format error, got %v", err)
+	}
+
+	notEncrypted := filepath.Join(dir, "plain.yaml")
+	err = os.WriteFile(notEncrypted, []byte("password: plain\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Read(notEncrypted)
+	if err == nil || !strings.Contains(err.Error(), "sops --decrypt failed: Error unmarshalling input: sops metadata not found") {
+		t.Errorf("expected the error of sops, got %v", err)
+	}
+
+	notMap := filepath.Join(dir, "list.yaml")
+	secretstest.Encrypt(t, notMap, "- list-s3cr3t\n")
+	_, err = Read(notMap)
+	if err == nil || strings.Contains(err.Error(), "list-s3cr3t") {
+		t.Errorf("expected an error without the plaintext, got %v", err)
+	}
+
+	encrypted := filepath.Join(dir, "other-key.hcl")
+	secretstest.Encrypt(t, encrypted, "password = \"other-s3cr3t\"\n")
+	_, err = Read(encrypted)
+	if err != nil {
+		t.Fatalf("expected the HCL file to be decrypted as binary, got %v", err)
+	}
+	secretstest.Install(t)
+	_, err = Read(encrypted)
+	if err == nil || !strings.Contains(err.Error(), "Failed to get the data key") {
+		t.Errorf("expected a file of another key not to decrypt, got %v", err)
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	_, err = Read(encrypted)
+	if err == nil || !strings.Contains(err.Error(), "sops not found in PATH") {
+		t.Errorf("expected a missing sops error, got %v", err)
+	}
+
+	t.Setenv("SOPS_AGE_KEY_FILE", filepath.Join(dir, "missing"))
+	encrypted = filepath.Join(dir, "production.hcl.age")
+	err = os.WriteFile(encrypted, []byte("age-encryption.org/v1\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Read(encrypted)
+	if err == nil || !strings.Contains(err.Error(), "could not read the age keys") {
+		t.Errorf("expected a missing keys error, got %v", err)
+	}
+}
+
+func TestAddString(t *testing.T) {
+	addString("{\n  add-string-s3cr3t\n  ab\n}")
+	addString("global")
+	addString("dc1")
+	addString("......")
+	redacted := redact.String("{ ab add-string-s3cr3t } dc1 ...... global")
+	expected := "{ ab " + redact.Mask + " } dc1 ...... " + redact.Mask
+	if redacted != expected {
+		t.Errorf("expected only the long values and lines to be registered, %q, got %q", expected, redacted)
+	}
+}
+
+func TestWriteVarFiles(t *testing.T) {
+	files := []File{
+		{Name: "production.hcl", Format: FormatHCL, Content: []byte(`token = "a"`)},
+		{Name: "production.yaml", Format: FormatYAML, Values: map[string]any{"b": "b"}},
+		{Name: "production.hcl", Format: FormatHCL, Content: []byte(`token = "c"`)},
+	}
+
+	paths, remove, err := WriteVarFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("expected a var file per HCL file, got %v", paths)
+	}
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("expected %s to only be readable by its owner, got %s", path, info.Mode().Perm())
+		}
+		content, _ := os.ReadFile(path)
+		if string(content) != string(files[i*2].Content) {
+			t.Errorf("expected %s to contain %q, got %q", path, files[i*2].Content, content)
+		}
+	}
+
+	remove()
+	for _, path := range paths {
+		if _, err := os.Stat(filepath.Dir(path)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", filepath.Dir(path), err)
+		}
+	}
+
+	paths, remove, err = WriteVarFiles(files[1:2])
+	if err != nil || len(paths) != 0 {
+		t.Errorf("expected no var files, got %v %v", paths, err)
+	}
+	remove()
+}
+
+func TestMerge(t *testing.T) {
+	values := Merge([]File{
+		{Values: map[string]any{"a": "environment", "b": "environment"}},
+		{Format: FormatHCL},
+		{Values: map[string]any{"b": "release"}},
+	})
+	if values["a"] != "environment" || values["b"] != "release" {
+		t.Errorf("expected the values of later files to take precedence, got %v", values)
+	}
+}
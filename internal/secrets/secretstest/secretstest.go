@@ -0,0 +1,114 @@
+// Package secretstest encrypts secrets files with age, with a key generated for the test, and
+// provides a fake sops binary, to test code reading them.
+package secretstest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// Header is the start of the first line of the files "encrypted" for sops by Encrypt. It is
+// followed by the key file they are encrypted for, the fake sops fails to decrypt files without it
+// or for another key file.
+const Header = "fake-sops-encrypted-for: "
+
+// The fake sops prints the file, given as last argument, without its header. Like sops, files
+// other than YAML ones are only decrypted as binary.
+const fakeSOPS = `#!/bin/sh
+for file; do :; done
+case "$(head -n 1 "$file")" in
+"` + Header + `$SOPS_AGE_KEY_FILE") ;;
+"` + Header + `"*)
+	echo "Failed to get the data key required to decrypt the SOPS file." >&2
+	exit 128
+	;;
+*)
+	echo "Error unmarshalling input: sops metadata not found" >&2
+	exit 1
+	;;
+esac
+case "$file" in
+*.yaml | *.yml) ;;
+*)
+	case " $* " in
+	*" --input-type binary --output-type binary "*) ;;
+	*)
+		echo "Error unmarshalling input: $file is not in the binary format" >&2
+		exit 1
+		;;
+	esac
+	;;
+esac
+tail -n +2 "$file"
+`
+
+// Install generates an age key and makes it the local key, SOPS_AGE_KEY_FILE, and puts a fake sops
+// binary first in the PATH for the duration of the test. Files encrypted before for another key
+// can no longer be decrypted.
+func Install(t *testing.T) *age.X25519Identity {
+	t.Helper()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	keys := filepath.Join(dir, "keys.txt")
+	err = os.WriteFile(keys, []byte(identity.String()+"\n"), 0600)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "sops"), []byte(fakeSOPS), 0755)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("SOPS_AGE_KEY", "")
+	t.Setenv("SOPS_AGE_KEY_FILE", keys)
+	return identity
+}
+
+// Encrypt encrypts plaintext for the key of Install and writes it to path: with age, armored, when
+// it ends with .age, otherwise for the fake sops.
+func Encrypt(t *testing.T, path, plaintext string) {
+	t.Helper()
+	keysPath := os.Getenv("SOPS_AGE_KEY_FILE")
+	keys, err := os.ReadFile(keysPath)
+	if err != nil {
+		t.Fatalf("secretstest.Install must be called before Encrypt: %v", err)
+	}
+	if filepath.Ext(path) != ".age" {
+		err = os.WriteFile(path, []byte(Header+keysPath+"\n"+plaintext), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	identity, err := age.ParseX25519Identity(strings.TrimSpace(string(keys)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := bytes.Buffer{}
+	armored := armor.NewWriter(&encrypted)
+	w, err := age.Encrypt(armored, identity.Recipient())
+	if err == nil {
+		_, err = w.Write([]byte(plaintext))
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = armored.Close()
+	}
+	if err == nil {
+		err = os.WriteFile(path, encrypted.Bytes(), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
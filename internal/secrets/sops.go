@@ -0,0 +1,31 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// decryptSOPS decrypts the file at path with the sops binary, which supports every kind of key
+// (age, PGP, KMS...) and verifies the MAC of the file. sops has no HCL support, such files are
+// encrypted as binary.
+func decryptSOPS(path, format string) ([]byte, error) {
+	cmd := exec.Command("sops", "--decrypt", path)
+	if format != FormatYAML {
+		cmd = exec.Command("sops", "--decrypt", "--input-type", "binary", "--output-type", "binary", path)
+	}
+
+	stderr := bytes.Buffer{}
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, errors.New("sops not found in PATH, it is needed to decrypt the file")
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("sops --decrypt failed: %s", strings.TrimSpace(stderr.String()))
+	}
+	return output, err
+}
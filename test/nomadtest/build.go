@@ -0,0 +1,80 @@
+package nomadtest
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/magec/nomad-packfile/test"
+)
+
+var build struct {
+	once   sync.Once
+	binary string
+	err    error
+}
+
+// BuildNomadPack builds the fake nomad-pack (see the nomadpack command in this directory), once
+// per test binary, and returns its path. HOME is set to a temporary directory so the calls and
+// registries it records are local to the test; Calls returns them.
+func BuildNomadPack(t *testing.T) string {
+	t.Helper()
+	build.once.Do(func() {
+		var dir string
+		dir, build.err = os.MkdirTemp("", "fake-nomad-pack-")
+		if build.err != nil {
+			return
+		}
+		build.binary = filepath.Join(dir, "nomad-pack")
+		cmd := exec.Command("go", "build", "-o", build.binary, "./test/nomadtest/nomadpack")
+		cmd.Dir = test.ProjectRoot()
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			build.err = fmt.Errorf("%w\n%s", err, output)
+		}
+	})
+	if build.err != nil {
+		t.Fatalf("could not build the fake nomad-pack: %v", build.err)
+	}
+
+	t.Setenv("HOME", t.TempDir())
+	return build.binary
+}
+
+// Calls returns the command lines the fake nomad-pack has been run with, in order.
+func Calls(t *testing.T) []string {
+	t.Helper()
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(home, ".fake-nomad-pack", "calls"))
+	if os.IsNotExist(err) {
+		return []string{}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+// SetNomadPackVersion sets the version reported by the fake nomad-pack in the current test.
+func SetNomadPackVersion(t *testing.T, version string) {
+	t.Helper()
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(home, ".fake-nomad-pack")
+	err = os.MkdirAll(dir, 0755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "version"), []byte(version), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,455 @@
+// Command nomadpack is a fake nomad-pack working against the Nomad API of NOMAD_ADDR, built by
+// nomadtest.BuildNomadPack. It supports version, registry add/list, plan, run, render and destroy.
+//
+// Every pack defines a single job, named after the job_name var or the pack. Its type and count
+// are taken from the job_type and count vars, the job_id and job_namespace vars set its id and
+// namespace attributes. Jobs without namespace go to the one of NOMAD_NAMESPACE. Every invocation is appended to
+// $HOME/.fake-nomad-pack/calls and the registries added are kept in $HOME/.fake-nomad-pack/registries.
+//
+// The version it reports is read from $HOME/.fake-nomad-pack/version (0.1.2 by default); like the
+// real ones, versions older than 0.1.0 do not know --exit-code-makes-changes, --to-dir and --ref.
+// The environment variable FAKE_NOMAD_PACK_FAIL makes the given comma separated commands fail,
+// e.g. run,plan.
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultVersion = "0.1.2"
+
+// invocation holds the parsed command line of a pack command.
+type invocation struct {
+	command             string
+	pack                string
+	registry            string
+	ref                 string
+	toDir               string
+	varFiles            []string
+	vars                map[string]string
+	exitCodeMakeChanges int
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	recordCall(args)
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: nomad-pack <command> [args]")
+		return 1
+	}
+	if slices.Contains(strings.Split(os.Getenv("FAKE_NOMAD_PACK_FAIL"), ","), args[0]) {
+		fmt.Fprintf(os.Stderr, "! Failed to %s: scripted failure\n", args[0])
+		return 1
+	}
+
+	switch args[0] {
+	case "version":
+		fmt.Printf("Nomad Pack v%s (fake)\n", version())
+		return 0
+	case "registry":
+		return registry(args[1:])
+	case "plan", "run", "render", "destroy":
+		inv, err := parse(args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "!", err)
+			return 1
+		}
+		return packCommand(inv)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		return 1
+	}
+}
+
+func version() string {
+	content, err := os.ReadFile(filepath.Join(stateDir(), "version"))
+	if err != nil {
+		return defaultVersion
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// legacy returns whether the version predates the version dependent flags.
+func legacy() bool {
+	return strings.HasPrefix(version(), "0.0.")
+}
+
+func stateDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".fake-nomad-pack")
+}
+
+func recordCall(args []string) {
+	dir := stateDir()
+	_ = os.MkdirAll(dir, 0755)
+	file, err := os.OpenFile(filepath.Join(dir, "calls"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, strings.Join(args, " "))
+}
+
+func registry(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: nomad-pack registry add|list")
+		return 1
+	}
+	dir := filepath.Join(stateDir(), "registries")
+	switch args[0] {
+	case "add":
+		positional, flags := splitFlags(args[1:])
+		if _, ok := flags["ref"]; ok && legacy() {
+			fmt.Fprintln(os.Stderr, "! unknown flag --ref")
+			return 1
+		}
+		if len(positional) != 2 {
+			fmt.Fprintln(os.Stderr, "! registry add requires a name and a source")
+			return 1
+		}
+		name, source := positional[0], positional[1]
+		if !strings.Contains(source, "/") {
+			fmt.Fprintf(os.Stderr, "! Failed to clone registry %s: invalid source %s\n", name, source)
+			return 1
+		}
+		ref := flags["ref"]
+		if ref == "" {
+			ref = "latest"
+		}
+		_ = os.MkdirAll(dir, 0755)
+		err := os.WriteFile(filepath.Join(dir, name+"@"+ref), []byte(source), 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "!", err)
+			return 1
+		}
+		fmt.Printf("Registry %s@%s added from %s\n", name, ref, source)
+		return 0
+	case "list":
+		entries, _ := os.ReadDir(dir)
+		fmt.Println("REGISTRY NAME | REF    | REGISTRY URL")
+		for _, entry := range entries {
+			name, ref, _ := strings.Cut(entry.Name(), "@")
+			source, _ := os.ReadFile(filepath.Join(dir, entry.Name()))
+			fmt.Printf("%s | %s | %s\n", name, ref, source)
+		}
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown registry command %q\n", args[0])
+		return 1
+	}
+}
+
+// splitFlags separates the positional arguments from the --flag value ones.
+func splitFlags(args []string) (positional []string, flags map[string]string) {
+	flags = map[string]string{}
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "-") {
+			positional = append(positional, args[i])
+			continue
+		}
+		name := strings.TrimLeft(args[i], "-")
+		if key, value, found := strings.Cut(name, "="); found {
+			flags[key] = value
+			continue
+		}
+		if i+1 < len(args) {
+			flags[name] = args[i+1]
+			i++
+		}
+	}
+	return positional, flags
+}
+
+func parse(args []string) (invocation, error) {
+	inv := invocation{command: args[0], vars: map[string]string{}}
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if legacy() && (arg == "--to-dir" || arg == "--ref" || strings.HasPrefix(arg, "--exit-code-makes-changes")) {
+			return inv, fmt.Errorf("unknown flag %s", arg)
+		}
+		value := func() string {
+			i++
+			if i < len(args) {
+				return args[i]
+			}
+			return ""
+		}
+		switch {
+		case arg == "-var-file" || arg == "--var-file":
+			inv.varFiles = append(inv.varFiles, value())
+		case arg == "-var" || arg == "--var":
+			key, val, _ := strings.Cut(value(), "=")
+			inv.vars[key] = val
+		case arg == "--registry":
+			inv.registry = value()
+		case arg == "--ref":
+			inv.ref = value()
+		case arg == "--to-dir":
+			inv.toDir = value()
+		case arg == "--diff":
+		case strings.HasPrefix(arg, "--exit-code-makes-changes="):
+			inv.exitCodeMakeChanges, _ = strconv.Atoi(strings.TrimPrefix(arg, "--exit-code-makes-changes="))
+		case strings.HasPrefix(arg, "-"):
+			return inv, fmt.Errorf("unknown flag %s", arg)
+		default:
+			inv.pack = arg
+		}
+	}
+	if inv.pack == "" {
+		return inv, fmt.Errorf("a pack name is required")
+	}
+	return inv, nil
+}
+
+// jobName is the label of the job.
+func (inv invocation) jobName() string {
+	if name := inv.vars["job_name"]; name != "" {
+		return name
+	}
+	return path.Base(inv.pack)
+}
+
+func (inv invocation) jobID() string {
+	if id := inv.vars["job_id"]; id != "" {
+		return id
+	}
+	return inv.jobName()
+}
+
+func (inv invocation) namespace() string {
+	if namespace := inv.vars["job_namespace"]; namespace != "" {
+		return namespace
+	}
+	return os.Getenv("NOMAD_NAMESPACE")
+}
+
+// jobPath returns the API path of the job in its namespace, with the given query parameters.
+func (inv invocation) jobPath(query url.Values) string {
+	if query == nil {
+		query = url.Values{}
+	}
+	if namespace := inv.namespace(); namespace != "" {
+		query.Set("namespace", namespace)
+	}
+	endpoint := "/v1/job/" + url.PathEscape(inv.jobID())
+	if len(query) == 0 {
+		return endpoint
+	}
+	return endpoint + "?" + query.Encode()
+}
+
+// hash identifies the inputs of the invocation, it is stored in the job meta to detect changes.
+func (inv invocation) hash() (string, error) {
+	h := sha256.New()
+	fmt.Fprintln(h, inv.pack, inv.registry, inv.ref)
+	for _, varFile := range inv.varFiles {
+		content, err := os.ReadFile(varFile)
+		if err != nil {
+			return "", fmt.Errorf("could not read var file: %w", err)
+		}
+		h.Write(content)
+	}
+	keys := []string{}
+	for key := range inv.vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintln(h, key, inv.vars[key])
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12], nil
+}
+
+func (inv invocation) job() (map[string]any, error) {
+	hash, err := inv.hash()
+	if err != nil {
+		return nil, err
+	}
+	jobType := inv.vars["job_type"]
+	if jobType == "" {
+		jobType = "service"
+	}
+	count := 1
+	if c := inv.vars["count"]; c != "" {
+		count, err = strconv.Atoi(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid count %q", c)
+		}
+	}
+	job := map[string]any{
+		"ID":         inv.jobID(),
+		"Name":       inv.jobName(),
+		"Type":       jobType,
+		"TaskGroups": []map[string]any{{"Name": "app", "Count": count}},
+		"Meta":       map[string]string{"pack": inv.pack, "inputs": hash},
+	}
+	if namespace := inv.namespace(); namespace != "" {
+		job["Namespace"] = namespace
+	}
+	return job, nil
+}
+
+func packCommand(inv invocation) int {
+	var err error
+	code := 0
+	switch inv.command {
+	case "render":
+		err = render(inv)
+	case "run":
+		err = register(inv)
+	case "plan":
+		code, err = plan(inv)
+	case "destroy":
+		err = destroy(inv)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "!", err)
+		return 1
+	}
+	return code
+}
+
+func render(inv invocation) error {
+	job, err := inv.job()
+	if err != nil {
+		return err
+	}
+	name := path.Base(inv.pack)
+	attributes := ""
+	if id := inv.vars["job_id"]; id != "" {
+		attributes += fmt.Sprintf("  id = %q\n", id)
+	}
+	if namespace := inv.vars["job_namespace"]; namespace != "" {
+		attributes += fmt.Sprintf("  namespace = %q\n", namespace)
+	}
+	rendered := fmt.Sprintf("job %q {\n%s  type = %q\n  meta {\n    inputs = %q\n  }\n}\n", job["Name"], attributes, job["Type"], job["Meta"].(map[string]string)["inputs"])
+	if inv.toDir != "" {
+		dir := filepath.Join(inv.toDir, name, "templates")
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, name+".nomad"), []byte(rendered), 0644)
+	}
+	fmt.Printf("%s/templates/%s.nomad.tpl:\n\n%s\n", name, name, rendered)
+	return nil
+}
+
+func register(inv invocation) error {
+	job, err := inv.job()
+	if err != nil {
+		return err
+	}
+	err = nomadRequest(http.MethodPut, "/v1/jobs", map[string]any{"Job": job}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to register job %s: %w", job["ID"], err)
+	}
+	fmt.Printf("Job '%s' in pack deployment '%s' registered successfully\n", job["ID"], path.Base(inv.pack))
+	fmt.Println("Pack successfully deployed.")
+	return nil
+}
+
+func plan(inv invocation) (int, error) {
+	job, err := inv.job()
+	if err != nil {
+		return 0, err
+	}
+	var current struct{ Meta map[string]string }
+	found := true
+	err = nomadRequest(http.MethodGet, inv.jobPath(nil), nil, &current)
+	if err == errNotFound {
+		found, err = false, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	inputs := job["Meta"].(map[string]string)["inputs"]
+	switch {
+	case !found:
+		fmt.Printf("+ Job: %q\n", job["ID"])
+	case current.Meta["inputs"] != inputs:
+		fmt.Printf("+/- Job: %q\n+/- Meta[inputs]: %q => %q\n", job["ID"], current.Meta["inputs"], inputs)
+	default:
+		fmt.Printf("Job: %q\n", job["ID"])
+		fmt.Println("Plan succeeded")
+		return 0, nil
+	}
+	fmt.Println("Plan succeeded")
+	if inv.exitCodeMakeChanges != 0 {
+		return inv.exitCodeMakeChanges, nil
+	}
+	return 1, nil
+}
+
+func destroy(inv invocation) error {
+	err := nomadRequest(http.MethodDelete, inv.jobPath(url.Values{"purge": {"true"}}), nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to destroy job %s: %w", inv.jobID(), err)
+	}
+	fmt.Printf("Job %q destroyed\n", inv.jobID())
+	return nil
+}
+
+type notFoundError struct{}
+
+func (notFoundError) Error() string { return "not found" }
+
+var errNotFound error = notFoundError{}
+
+// nomadRequest calls the Nomad API of NOMAD_ADDR with NOMAD_TOKEN, decoding the response into out.
+func nomadRequest(method, endpoint string, in, out any) error {
+	address := os.Getenv("NOMAD_ADDR")
+	if address == "" {
+		address = "http://127.0.0.1:4646"
+	}
+	var body io.Reader
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(encoded)
+	}
+	request, err := http.NewRequest(method, strings.TrimSuffix(address, "/")+endpoint, body)
+	if err != nil {
+		return err
+	}
+	if token := os.Getenv("NOMAD_TOKEN"); token != "" {
+		request.Header.Set("X-Nomad-Token", token)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if response.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("unexpected response code %d (%s)", response.StatusCode, strings.TrimSpace(string(message)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(out)
+}
@@ -0,0 +1,524 @@
+// Package nomadtest provides a fake Nomad API server, and a fake nomad-pack binary working against
+// it, to test nomad-packfile end to end without a Nomad cluster nor network access.
+package nomadtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+)
+
+// DefaultVersion is the Nomad version reported by the server.
+const DefaultVersion = "1.8.3"
+
+// Server is a fake Nomad API server keeping jobs, deployments and variables in memory. Job IDs are
+// unique across namespaces, jobs are only found in the namespace they were registered in. It implements
+// the endpoints used by nomad-packfile and the fake nomad-pack: status, agent self, ACL token self,
+// namespaces, jobs (parse, register, plan, info, versions, revert, summary, deregister), deployments
+// and variables.
+type Server struct {
+	// URL is the address of the server, to be used as nomad-addr.
+	URL string
+
+	mu sync.Mutex
+	// Version is the Nomad version reported by agent self.
+	Version string
+	// Token, when set, is the only token accepted; every other request is denied. When empty ACLs
+	// are disabled.
+	Token string
+	// Namespaces are the existing namespaces.
+	Namespaces []string
+	// DeploymentStatus is the status of the deployments created for new versions of service jobs.
+	DeploymentStatus string
+	// Denied are prefixes of the paths denied to every token, e.g. /v1/agent/ for a token without
+	// agent:read.
+	Denied []string
+
+	index       uint64
+	jobs        map[string][]*nomad.Job
+	deployments map[string]*nomad.Deployment
+	variables   map[string]*nomad.Variable
+	requests    []string
+}
+
+// NewServer starts a Server, it is closed when the test finishes.
+func NewServer(t *testing.T) *Server {
+	t.Helper()
+	server := &Server{
+		Version:          DefaultVersion,
+		Namespaces:       []string{"default"},
+		DeploymentStatus: "successful",
+		index:            1,
+		jobs:             map[string][]*nomad.Job{},
+		deployments:      map[string]*nomad.Deployment{},
+		variables:        map[string]*nomad.Variable{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/status/peers", server.handlePeers)
+	mux.HandleFunc("GET /v1/status/leader", server.handleLeader)
+	mux.HandleFunc("GET /v1/agent/self", server.handleAgentSelf)
+	mux.HandleFunc("GET /v1/acl/token/self", server.handleTokenSelf)
+	mux.HandleFunc("GET /v1/namespace/{name}", server.handleNamespace)
+	mux.HandleFunc("/v1/jobs", server.handleRegister)
+	mux.HandleFunc("PUT /v1/jobs/parse", server.handleParse)
+	mux.HandleFunc("GET /v1/job/{id}", server.handleJob)
+	mux.HandleFunc("DELETE /v1/job/{id}", server.handleDeregister)
+	mux.HandleFunc("/v1/job/{id}/plan", server.handlePlan)
+	mux.HandleFunc("GET /v1/job/{id}/versions", server.handleVersions)
+	mux.HandleFunc("/v1/job/{id}/revert", server.handleRevert)
+	mux.HandleFunc("GET /v1/job/{id}/summary", server.handleSummary)
+	mux.HandleFunc("GET /v1/job/{id}/deployment", server.handleLatestDeployment)
+	mux.HandleFunc("GET /v1/deployment/allocations/{id}", server.handleDeploymentAllocations)
+	mux.HandleFunc("GET /v1/vars", server.handleListVariables)
+	mux.HandleFunc("GET /v1/var/{path...}", server.handleReadVariable)
+	mux.HandleFunc("PUT /v1/var/{path...}", server.handleWriteVariable)
+
+	httpServer := httptest.NewServer(server.authorize(mux))
+	t.Cleanup(httpServer.Close)
+	server.URL = httpServer.URL
+	return server
+}
+
+// Requests returns the requests received so far, in the form "METHOD /path".
+func (server *Server) Requests() []string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return slices.Clone(server.requests)
+}
+
+// Job returns the latest version of the job, nil if it does not exist.
+func (server *Server) Job(id string) *nomad.Job {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.latest(id)
+}
+
+// JobVersions returns every version of the job, oldest first.
+func (server *Server) JobVersions(id string) []*nomad.Job {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return slices.Clone(server.jobs[id])
+}
+
+// Variable returns the variable at path, nil if it does not exist.
+func (server *Server) Variable(path string) *nomad.Variable {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.variables[path]
+}
+
+// SetDeploymentStatus sets the status of the deployments created from now on.
+func (server *Server) SetDeploymentStatus(status string) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.DeploymentStatus = status
+}
+
+// authorize records the request and rejects it unless it carries the expected token.
+func (server *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		server.requests = append(server.requests, r.Method+" "+r.URL.Path)
+		token := server.Token
+		denied := slices.ContainsFunc(server.Denied, func(prefix string) bool { return strings.HasPrefix(r.URL.Path, prefix) })
+		server.mu.Unlock()
+
+		if denied {
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+
+		// Like in Nomad, the status endpoints do not need a token.
+		if token != "" && !strings.HasPrefix(r.URL.Path, "/v1/status/") && r.Header.Get("X-Nomad-Token") != token {
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// respond writes value as JSON along with the headers the Nomad API client expects.
+func (server *Server) respond(w http.ResponseWriter, status int, value any) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Nomad-Index", strconv.FormatUint(server.index, 10))
+	w.Header().Set("X-Nomad-LastContact", "0")
+	w.Header().Set("X-Nomad-KnownLeader", "true")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+func (server *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	server.respond(w, http.StatusOK, []string{"127.0.0.1:4647"})
+}
+
+func (server *Server) handleLeader(w http.ResponseWriter, r *http.Request) {
+	server.respond(w, http.StatusOK, "127.0.0.1:4647")
+}
+
+func (server *Server) handleAgentSelf(w http.ResponseWriter, r *http.Request) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.respond(w, http.StatusOK, nomad.AgentSelf{
+		Member: nomad.AgentMember{Name: "server-1", Tags: map[string]string{"build": server.Version}},
+	})
+}
+
+func (server *Server) handleTokenSelf(w http.ResponseWriter, r *http.Request) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.Token == "" {
+		http.Error(w, "ACL support disabled", http.StatusBadRequest)
+		return
+	}
+	server.respond(w, http.StatusOK, nomad.ACLToken{AccessorID: "fake-accessor", Name: "fake", Type: "management"})
+}
+
+func (server *Server) handleNamespace(w http.ResponseWriter, r *http.Request) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	name := r.PathValue("name")
+	if !slices.Contains(server.Namespaces, name) {
+		http.Error(w, "namespace not found", http.StatusNotFound)
+		return
+	}
+	server.respond(w, http.StatusOK, nomad.Namespace{Name: name})
+}
+
+func (server *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	var request nomad.JobRegisterRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.Job == nil || request.Job.ID == nil {
+		http.Error(w, "invalid job", http.StatusBadRequest)
+		return
+	}
+
+	if request.Job.Namespace == nil {
+		namespace := namespace(r)
+		request.Job.Namespace = &namespace
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if !slices.Contains(server.Namespaces, *request.Job.Namespace) {
+		http.Error(w, "namespace not found", http.StatusBadRequest)
+		return
+	}
+	server.register(request.Job)
+	server.respond(w, http.StatusOK, nomad.JobRegisterResponse{EvalID: server.id("eval")})
+}
+
+var (
+	parsedJob       = regexp.MustCompile(`(?m)^\s*job\s+"([^"]*)"\s*\{`)
+	parsedAttribute = regexp.MustCompile(`(?m)^  (id|namespace)\s*=\s*"([^"]*)"\s*$`)
+)
+
+// handleParse parses jobs like the ones the fake nomad-pack renders: the label of the job block and
+// its id and namespace attributes, which have to be literal strings.
+func (server *Server) handleParse(w http.ResponseWriter, r *http.Request) {
+	var request nomad.JobsParseRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	match := parsedJob.FindStringSubmatch(request.JobHCL)
+	if err != nil || match == nil {
+		http.Error(w, "error parsing: no job block", http.StatusBadRequest)
+		return
+	}
+	name := match[1]
+	job := &nomad.Job{Name: &name, ID: &name}
+	for _, attribute := range parsedAttribute.FindAllStringSubmatch(request.JobHCL, -1) {
+		value := attribute[2]
+		if strings.Contains(value, "${") {
+			http.Error(w, fmt.Sprintf("error parsing: unknown variable in %s", attribute[1]), http.StatusBadRequest)
+			return
+		}
+		if attribute[1] == "id" {
+			job.ID = &value
+		} else {
+			job.Namespace = &value
+		}
+	}
+	server.respond(w, http.StatusOK, job)
+}
+
+// register stores job as a new version and, for service jobs, creates its deployment.
+func (server *Server) register(job *nomad.Job) {
+	server.index++
+	id := *job.ID
+	version := uint64(0)
+	if latest := server.latest(id); latest != nil {
+		version = *latest.Version + 1
+	}
+	jobType := "service"
+	if job.Type != nil {
+		jobType = *job.Type
+	}
+	status := "running"
+	stable := server.DeploymentStatus == "successful"
+	submitTime := time.Now().UnixNano()
+	job.Version, job.Type, job.Status, job.Stable, job.SubmitTime = &version, &jobType, &status, &stable, &submitTime
+	if job.Name == nil {
+		job.Name = job.ID
+	}
+	server.jobs[id] = append(server.jobs[id], job)
+
+	if jobType != "service" {
+		return
+	}
+	deployment := &nomad.Deployment{
+		ID:                server.id("deployment"),
+		JobID:             id,
+		JobVersion:        version,
+		Status:            server.DeploymentStatus,
+		StatusDescription: "Deployment " + server.DeploymentStatus,
+		TaskGroups:        map[string]*nomad.DeploymentState{},
+	}
+	for _, group := range job.TaskGroups {
+		state := &nomad.DeploymentState{DesiredTotal: count(group)}
+		if stable {
+			state.HealthyAllocs = state.DesiredTotal
+		}
+		deployment.TaskGroups[name(group)] = state
+	}
+	server.deployments[id] = deployment
+}
+
+func (server *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	job := server.requested(r)
+	if job == nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	server.respond(w, http.StatusOK, job)
+}
+
+func (server *Server) handleDeregister(w http.ResponseWriter, r *http.Request) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	id := r.PathValue("id")
+	job := server.requested(r)
+	if job == nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	server.index++
+	if r.URL.Query().Get("purge") == "true" {
+		delete(server.jobs, id)
+		delete(server.deployments, id)
+	} else {
+		stopped, dead := true, "dead"
+		job.Stop, job.Status = &stopped, &dead
+	}
+	server.respond(w, http.StatusOK, nomad.JobDeregisterResponse{EvalID: server.id("eval")})
+}
+
+func (server *Server) handlePlan(w http.ResponseWriter, r *http.Request) {
+	server.respond(w, http.StatusOK, nomad.JobPlanResponse{Diff: &nomad.JobDiff{Type: "None", ID: r.PathValue("id")}})
+}
+
+func (server *Server) handleVersions(w http.ResponseWriter, r *http.Request) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	versions := server.jobs[r.PathValue("id")]
+	if server.requested(r) == nil {
+		http.Error(w, "job versions not found", http.StatusNotFound)
+		return
+	}
+	// Nomad returns the newest version first.
+	newestFirst := slices.Clone(versions)
+	slices.Reverse(newestFirst)
+	server.respond(w, http.StatusOK, nomad.JobVersionsResponse{Versions: newestFirst})
+}
+
+func (server *Server) handleRevert(w http.ResponseWriter, r *http.Request) {
+	var request nomad.JobRevertRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "invalid revert request", http.StatusBadRequest)
+		return
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	id := r.PathValue("id")
+	latest := server.requested(r)
+	if latest == nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	if request.EnforcePriorVersion != nil && *request.EnforcePriorVersion != *latest.Version {
+		http.Error(w, fmt.Sprintf("current job has version %d; enforcing version %d", *latest.Version, *request.EnforcePriorVersion), http.StatusBadRequest)
+		return
+	}
+	for _, job := range server.jobs[id] {
+		if *job.Version == request.JobVersion {
+			reverted := *job
+			server.register(&reverted)
+			server.respond(w, http.StatusOK, nomad.JobRegisterResponse{EvalID: server.id("eval")})
+			return
+		}
+	}
+	http.Error(w, fmt.Sprintf("job %s version %d not found", id, request.JobVersion), http.StatusBadRequest)
+}
+
+func (server *Server) handleSummary(w http.ResponseWriter, r *http.Request) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	id := r.PathValue("id")
+	job := server.requested(r)
+	if job == nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	summary := nomad.JobSummary{JobID: id, Summary: map[string]nomad.TaskGroupSummary{}}
+	for _, group := range job.TaskGroups {
+		summary.Summary[name(group)] = nomad.TaskGroupSummary{Running: count(group)}
+	}
+	server.respond(w, http.StatusOK, summary)
+}
+
+func (server *Server) handleLatestDeployment(w http.ResponseWriter, r *http.Request) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.requested(r) == nil {
+		server.respond(w, http.StatusOK, nil)
+		return
+	}
+	server.respond(w, http.StatusOK, server.deployments[r.PathValue("id")])
+}
+
+func (server *Server) handleDeploymentAllocations(w http.ResponseWriter, r *http.Request) {
+	server.respond(w, http.StatusOK, []*nomad.AllocationListStub{})
+}
+
+func (server *Server) handleListVariables(w http.ResponseWriter, r *http.Request) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	prefix := r.URL.Query().Get("prefix")
+	list := []*nomad.VariableMetadata{}
+	for path, variable := range server.variables {
+		if strings.HasPrefix(path, prefix) {
+			list = append(list, &nomad.VariableMetadata{
+				Namespace:   variable.Namespace,
+				Path:        variable.Path,
+				CreateIndex: variable.CreateIndex,
+				ModifyIndex: variable.ModifyIndex,
+				CreateTime:  variable.CreateTime,
+				ModifyTime:  variable.ModifyTime,
+			})
+		}
+	}
+	slices.SortFunc(list, func(a, b *nomad.VariableMetadata) int { return strings.Compare(a.Path, b.Path) })
+	server.respond(w, http.StatusOK, list)
+}
+
+func (server *Server) handleReadVariable(w http.ResponseWriter, r *http.Request) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	variable := server.variables[r.PathValue("path")]
+	if variable == nil {
+		http.Error(w, "variable not found", http.StatusNotFound)
+		return
+	}
+	server.respond(w, http.StatusOK, variable)
+}
+
+func (server *Server) handleWriteVariable(w http.ResponseWriter, r *http.Request) {
+	var variable nomad.Variable
+	err := json.NewDecoder(r.Body).Decode(&variable)
+	if err != nil {
+		http.Error(w, "invalid variable", http.StatusBadRequest)
+		return
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	path := r.PathValue("path")
+	existing := server.variables[path]
+	if cas := r.URL.Query().Get("cas"); cas != "" {
+		index, _ := strconv.ParseUint(cas, 10, 64)
+		if (existing == nil && index != 0) || (existing != nil && existing.ModifyIndex != index) {
+			conflict := existing
+			if conflict == nil {
+				conflict = &nomad.Variable{}
+			}
+			server.respond(w, http.StatusConflict, conflict)
+			return
+		}
+	}
+
+	server.index++
+	now := time.Now().UnixNano()
+	variable.Path = path
+	if variable.Namespace == "" {
+		variable.Namespace = "default"
+	}
+	variable.CreateIndex, variable.CreateTime = server.index, now
+	if existing != nil {
+		variable.CreateIndex, variable.CreateTime = existing.CreateIndex, existing.CreateTime
+	}
+	variable.ModifyIndex, variable.ModifyTime = server.index, now
+	server.variables[path] = &variable
+	server.respond(w, http.StatusOK, variable)
+}
+
+// latest returns the latest version of the job, nil if it does not exist.
+func (server *Server) latest(id string) *nomad.Job {
+	versions := server.jobs[id]
+	if len(versions) == 0 {
+		return nil
+	}
+	return versions[len(versions)-1]
+}
+
+// requested returns the latest version of the job of the request, nil if it does not exist in the
+// namespace of the request.
+func (server *Server) requested(r *http.Request) *nomad.Job {
+	job := server.latest(r.PathValue("id"))
+	if job == nil || *job.Namespace != namespace(r) {
+		return nil
+	}
+	return job
+}
+
+// namespace returns the namespace of the request, like in Nomad default when it is not set.
+func namespace(r *http.Request) string {
+	if namespace := r.URL.Query().Get("namespace"); namespace != "" {
+		return namespace
+	}
+	return "default"
+}
+
+func (server *Server) id(kind string) string {
+	return fmt.Sprintf("%s-%d", kind, server.index)
+}
+
+func name(group *nomad.TaskGroup) string {
+	if group.Name == nil {
+		return ""
+	}
+	return *group.Name
+}
+
+func count(group *nomad.TaskGroup) int {
+	if group.Count == nil {
+		return 1
+	}
+	return *group.Count
+}